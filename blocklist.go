@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+)
+
+// loadBlocklist returns the ids that must never appear in the output, from the
+// -blocklist file and -block-ids.
+func loadBlocklist() map[string]bool {
+	blocked := make(map[string]bool)
+	if *blocklistFile != "" {
+		f, err := os.Open(*blocklistFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, id := range readIDLines(f) {
+			blocked[id] = true
+		}
+		f.Close()
+	}
+	if *blockIds != "" {
+		for _, id := range strings.Split(*blockIds, ",") {
+			blocked[strings.TrimSpace(id)] = true
+		}
+	}
+	return blocked
+}
+
+func filterBlocked(ids []string, blocked map[string]bool) ([]string, int) {
+	var kept []string
+	for _, id := range ids {
+		if !blocked[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept, len(ids) - len(kept)
+}
+
+// removeBlocked rewrites an output file without any blocked entries, returning
+// how many were removed. It must be called before the file is opened for
+// appending, since the rewrite replaces it.
+func removeBlocked(fname string, blocked map[string]bool) int {
+	if len(blocked) == 0 {
+		return 0
+	}
+	if _, err := os.Stat(fname); errors.Is(err, os.ErrNotExist) {
+		return 0
+	}
+	trimPartialLine(fname)
+
+	entries := readEntries(fname)
+	var kept []Entry
+	for _, entry := range entries {
+		if !blocked[entry.Id] {
+			kept = append(kept, entry)
+		}
+	}
+	removed := len(entries) - len(kept)
+	if removed > 0 {
+		rewriteOutput(fname, kept)
+		log.Printf("Removed %d blocked entries from %s", removed, fname)
+	}
+	return removed
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBlockedIdInIngestAndOutput(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	oldFile, oldIds := *blocklistFile, *blockIds
+	defer func() { *blocklistFile, *blockIds = oldFile, oldIds }()
+	*blocklistFile = filepath.Join(t.TempDir(), "blocklist.txt")
+	writeFile(t, *blocklistFile, "1003")
+	*blockIds = "4444, 5555"
+
+	writeFile(t, filepath.Join(*ingestDir, "region.ndjson"), `{"id":"1001"}`, `{"id":"1003"}`, `{"id":"5555"}`)
+	writeEntries(t, outputFile("region"), Entry{Id: "1003"}, Entry{Id: "2002"}, Entry{Id: "4444"})
+
+	blocked := loadBlocklist()
+	ids, n := filterBlocked(parseRegionIngest("region"), blocked)
+	if !slices.Equal(ids, []string{"1001"}) || n != 2 {
+		t.Errorf("ingest kept %v and blocked %d, want [1001] with 2 blocked", ids, n)
+	}
+	if removed := removeBlocked(outputFile("region"), blocked); removed != 2 {
+		t.Errorf("removed %d entries from the output, want 2", removed)
+	}
+
+	out, existing := openRegionOutput("region")
+	if _, err := processRegion(context.Background(), "region", ids, out, existing); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	if got := entryIds(readEntries(outputFile("region"))); !slices.Equal(got, []string{"2002", "1001"}) {
+		t.Errorf("output has %v, want [2002 1001]", got)
+	}
+}
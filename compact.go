@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// runCompact rewrites a region's output keeping only the newest entry for each
+// id that is still in the region's ingest files. It makes no API calls. A
+// region without ingest files, such as one filled by -search or -stdin, can't
+// be compacted, and one whose every entry would go needs -force, since that is
+// more likely a mismatched -ingest-dir or -ingest-ext than intended.
+func runCompact(args []string) {
+	flags := flag.NewFlagSet("compact", flag.ExitOnError)
+	force := flags.Bool("force", false, "Compact even if no entry would remain")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: compact [-force] <region>")
+	}
+	if partitioned() {
+		log.Fatal("compact does not support -partition-by")
+	}
+	region := flags.Arg(0)
+	fname := outputFile(region)
+
+	if len(regionIngestFiles(region)) == 0 {
+		log.Fatalf("Region %s has no ingest file in %s with an -ingest-ext extension, so compact would remove every entry", region, *ingestDir)
+	}
+	ingested := make(map[string]bool)
+	for _, id := range parseRegionIngest(region) {
+		ingested[id] = true
+	}
+
+	entries := readEntries(fname)
+	kept, duplicates, stale := compactEntries(entries, ingested)
+	if len(kept) == 0 && len(entries) > 0 && !*force {
+		log.Fatalf("None of the %d entries in %s are in the ingest files of %s, so leaving it as it is (use -force to empty it)", len(entries), fname, region)
+	}
+	rewriteOutput(fname, kept)
+	log.Printf("Compacted %s: removed %d duplicates and %d entries no longer ingested, %d remain",
+		fname, duplicates, stale, len(kept))
+}
+
+// compactEntries returns the newest entry for each ingested id, in the order
+// the ids first appear, along with how many duplicates and entries that are
+// no longer ingested were dropped.
+func compactEntries(entries []Entry, ingested map[string]bool) (kept []Entry, duplicates, stale int) {
+	newest := make(map[string]int)
+	var order []string
+	for i, entry := range entries {
+		if !ingested[entry.Id] {
+			stale++
+			continue
+		}
+		prev, seen := newest[entry.Id]
+		if !seen {
+			order = append(order, entry.Id)
+			newest[entry.Id] = i
+			continue
+		}
+		duplicates++
+		if !entry.RetrievedAt.Before(entries[prev].RetrievedAt) {
+			newest[entry.Id] = i
+		}
+	}
+
+	kept = make([]Entry, 0, len(order))
+	for _, id := range order {
+		kept = append(kept, entries[newest[id]])
+	}
+	return kept, duplicates, stale
+}
+
+// rewriteOutput atomically replaces fname with entries, writing to a temporary
+// file in the same directory and renaming it into place.
+func rewriteOutput(fname string, entries []Entry) {
+	tmp, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".tmp*")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if *schemaHeader {
+		writeSchemaHeader(tmp)
+	}
+	enc := json.NewEncoder(tmp)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := tmp.Chmod(fileMode); err != nil {
+		log.Fatal(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		log.Fatal(err)
+	}
+	if err := tmp.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp.Name(), fname); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCompactWithoutIngestFile(t *testing.T) {
+	useDirs(t)
+	writeEntries(t, outputFile("search"), Entry{Id: "1"}, Entry{Id: "2"})
+	expectFatal(t, "has no ingest file", func() { runCompact([]string{"search"}) })
+	if got := entryIds(readEntries(outputFile("search"))); !slices.Equal(got, []string{"1", "2"}) {
+		t.Errorf("output has %v, want it left as it was", got)
+	}
+}
+
+func TestCompactRefusesToEmptyOutput(t *testing.T) {
+	useDirs(t)
+	writeFile(t, filepath.Join(*ingestDir, "region.ndjson"), `{"id":"3"}`)
+	writeEntries(t, outputFile("region"), Entry{Id: "1"}, Entry{Id: "2"})
+	expectFatal(t, "use -force", func() { runCompact([]string{"region"}) })
+	if got := entryIds(readEntries(outputFile("region"))); !slices.Equal(got, []string{"1", "2"}) {
+		t.Errorf("output has %v, want it left as it was", got)
+	}
+
+	runCompact([]string{"-force", "region"})
+	if got := readEntries(outputFile("region")); len(got) != 0 {
+		t.Errorf("output has %v after -force, want it empty", entryIds(got))
+	}
+}
+
+func TestCompactEntries(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2024, 5, 1, hour, 0, 0, 0, time.UTC) }
+	entries := []Entry{
+		{Id: "1", Title: "old", RetrievedAt: at(1)},
+		{Id: "2", Title: "only", RetrievedAt: at(1)},
+		{Id: "stale", RetrievedAt: at(2)},
+		{Id: "1", Title: "new", RetrievedAt: at(3)},
+		{Id: "3", Title: "new", RetrievedAt: at(4)},
+		{Id: "3", Title: "old", RetrievedAt: at(2)},
+		{Id: "stale", RetrievedAt: at(5)},
+	}
+	ingested := map[string]bool{"1": true, "2": true, "3": true}
+
+	kept, duplicates, stale := compactEntries(entries, ingested)
+	if duplicates != 2 || stale != 2 {
+		t.Errorf("removed %d duplicates and %d stale entries, want 2 and 2", duplicates, stale)
+	}
+	// Each id stays where it first appeared, with its newest entry.
+	want := []string{"1 new", "2 only", "3 new"}
+	var got []string
+	for _, entry := range kept {
+		got = append(got, entry.Id+" "+entry.Title)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+// allowedContentTypes is set from -content-types. Nil allows every type.
+var allowedContentTypes map[int]bool
+
+func parseContentTypes(s string) map[int]bool {
+	if s == "" {
+		return nil
+	}
+	types := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		t, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || t < 1 || t > 3 {
+			log.Fatalf("Invalid -content-types %q: want a list of 1 (photo), 2 (screenshot) or 3 (other)", s)
+		}
+		types[t] = true
+	}
+	return types
+}
+
+// contentTypeAllowed reports whether a photo passes -content-types. Flickr
+// doesn't always say what type a photo is, and those with no type (0) are
+// kept rather than guessed at.
+func contentTypeAllowed(contentType int) bool {
+	return allowedContentTypes == nil || contentType == 0 || allowedContentTypes[contentType]
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContentTypeAllowed(t *testing.T) {
+	tests := []struct {
+		flag        string
+		contentType int
+		want        bool
+	}{
+		{"", 1, true},
+		{"", 3, true},
+		{"1", 1, true},
+		{"1", 2, false},
+		{"1", 3, false},
+		{"1, 3", 3, true},
+		{"1, 3", 2, false},
+		// Photos Flickr gives no type are kept whatever the flag says.
+		{"1", 0, true},
+	}
+	old := allowedContentTypes
+	defer func() { allowedContentTypes = old }()
+	for _, tt := range tests {
+		allowedContentTypes = parseContentTypes(tt.flag)
+		if got := contentTypeAllowed(tt.contentType); got != tt.want {
+			t.Errorf("-content-types %q allows type %d: %v, want %v", tt.flag, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestContentTypeFilter(t *testing.T) {
+	useFixtures(t)
+	old := allowedContentTypes
+	defer func() { allowedContentTypes = old }()
+	// 1001 is a photo and 1006 a video, which Flickr also calls a photo.
+	for _, tt := range []struct {
+		flag string
+		want SkipReason
+	}{
+		{"1", NotSkipped},
+		{"2,3", SkipContentType},
+	} {
+		allowedContentTypes = parseContentTypes(tt.flag)
+		for _, id := range []string{"1001", "1006"} {
+			entry, skip, err := createEntry(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if skip != tt.want {
+				t.Errorf("-content-types %s: %s got skip %q, want %q", tt.flag, id, skip, tt.want)
+			}
+			if skip == NotSkipped && entry.ContentType != 1 {
+				t.Errorf("%s has content type %d, want 1", id, entry.ContentType)
+			}
+		}
+	}
+}
+
+func TestParseContentTypesRejectsUnknown(t *testing.T) {
+	expectFatal(t, "Invalid -content-types", func() { parseContentTypes("1,4") })
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"text/tabwriter"
+)
+
+// runCount prints how many ids each region's ingest has, how many entries its
+// output has, and how many ingest ids are yet to be hydrated. It makes no API
+// calls and, unlike -dry-run, ignores the flags that change what a run would
+// fetch.
+func runCount(args []string) {
+	if len(args) != 0 {
+		log.Fatal("Usage: count")
+	}
+	writeCounts(os.Stdout)
+}
+
+func writeCounts(out io.Writer) {
+	ingests := loadIngestDir(*ingestDir, nil)
+	var regions []string
+	for region := range ingests {
+		regions = append(regions, region)
+	}
+	slices.Sort(regions)
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "region\tingest\toutput\tnew\t")
+	var totalIngest, totalOutput, totalNew int
+	for _, region := range regions {
+		ids := dedupIds(ingests[region])
+		existing := parseRegion(region)
+		newIds := 0
+		for _, id := range ids {
+			if _, ok := existing[id]; !ok {
+				newIds++
+			}
+		}
+
+		totalIngest += len(ids)
+		totalOutput += len(existing)
+		totalNew += newIds
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t\n", region, len(ids), len(existing), newIds)
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%d\t\n", totalIngest, totalOutput, totalNew)
+	w.Flush()
+}
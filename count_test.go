@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCount(t *testing.T) {
+	useDirs(t)
+	writeFile(t, filepath.Join(*ingestDir, "alps.ndjson"), `{"id":"1001"}`, `{"id":"1002"}`, `{"id":"1001"}`, `{"id":"1003"}`)
+	writeFile(t, filepath.Join(*ingestDir, "lakes.ndjson"), `{"id":"2001"}`)
+	writeEntries(t, outputFile("alps"), Entry{Id: "1001"}, Entry{Id: "1003"})
+	writeEntries(t, outputFile("lakes"), Entry{Id: "2001"}, Entry{Id: "9999"})
+
+	var buf bytes.Buffer
+	writeCounts(&buf)
+
+	// The ingest column counts ids once however often they're listed, and
+	// new ones are those with no entry.
+	tests := []struct {
+		region string
+		want   []string
+	}{
+		{"alps", []string{"alps", "3", "2", "1"}},
+		{"lakes", []string{"lakes", "1", "2", "0"}},
+		{"total", []string{"total", "4", "4", "1"}},
+	}
+	rows := make(map[string][]string)
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n")[1:] {
+		fields := strings.Fields(line)
+		rows[fields[0]] = fields
+	}
+	if len(rows) != len(tests) {
+		t.Fatalf("got rows for %d regions, want %d:\n%s", len(rows), len(tests), buf.Bytes())
+	}
+	for _, tt := range tests {
+		if got := strings.Join(rows[tt.region], " "); got != strings.Join(tt.want, " ") {
+			t.Errorf("%s row is %q, want %q", tt.region, got, strings.Join(tt.want, " "))
+		}
+	}
+}
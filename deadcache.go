@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// deadCache remembers photos that Flickr reported as not found, so that reruns
+// don't keep requesting them. Entries expire in case a photo comes back.
+type deadCache struct {
+	fname string
+	dead  map[string]time.Time
+}
+
+func loadDeadCache(region string, ttl time.Duration) *deadCache {
+	c := &deadCache{fname: outPath(region + ".dead-cache.json"), dead: make(map[string]time.Time)}
+	data, err := os.ReadFile(c.fname)
+	if errors.Is(err, os.ErrNotExist) {
+		return c
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &c.dead); err != nil {
+		log.Fatalf("Parsing %s: %s", c.fname, err)
+	}
+	for id, at := range c.dead {
+		if time.Since(at) > ttl {
+			delete(c.dead, id)
+		}
+	}
+	return c
+}
+
+func (c *deadCache) isDead(id string) bool {
+	_, ok := c.dead[id]
+	return ok
+}
+
+func (c *deadCache) markDead(id string) {
+	c.dead[id] = time.Now().UTC()
+}
+
+func (c *deadCache) save() {
+	if len(c.dead) == 0 {
+		if err := os.Remove(c.fname); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatal(err)
+		}
+		return
+	}
+	data, err := json.MarshalIndent(c.dead, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(c.fname, data, fileMode); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// isNotFound reports whether err is Flickr saying the photo doesn't exist.
+func isNotFound(err error) bool {
+	var flickrErr *FlickrError
+	return errors.As(err, &flickrErr) && flickrErr.Method == "flickr.photos.getInfo" && flickrErr.Code == 1
+}
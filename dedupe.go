@@ -0,0 +1,47 @@
+package main
+
+import "log"
+
+const (
+	dedupeByID        = "id"
+	dedupeByOwnerDate = "owner-date"
+	dedupeByImageHash = "image-hash"
+)
+
+func checkDedupeBy() {
+	switch *dedupeBy {
+	case dedupeByID, dedupeByOwnerDate:
+	case dedupeByImageHash:
+		if !*checksumImages {
+			log.Fatal("-dedupe-by image-hash requires -checksum-images")
+		}
+	default:
+		log.Fatalf("-dedupe-by must be %s, %s or %s", dedupeByID, dedupeByOwnerDate, dedupeByImageHash)
+	}
+}
+
+// dedupeKey returns the key under which -dedupe-by considers two entries the
+// same photo. Entries missing the fields a mode needs fall back to their id,
+// so they are never deduplicated against each other.
+func dedupeKey(entry Entry) string {
+	switch *dedupeBy {
+	case dedupeByOwnerDate:
+		if entry.OwnerNSID != "" && entry.DateTaken != "" {
+			return "owner-date:" + entry.OwnerNSID + "|" + entry.DateTaken + "|" + entry.Title
+		}
+	case dedupeByImageHash:
+		if entry.ImageHash != "" {
+			return "image-hash:" + entry.ImageHash
+		}
+	}
+	return "id:" + entry.Id
+}
+
+// dedupeIndex maps the dedupe key of each entry to its id.
+func dedupeIndex(entries map[string]Entry) map[string]string {
+	index := make(map[string]string, len(entries))
+	for id, entry := range entries {
+		index[dedupeKey(entry)] = id
+	}
+	return index
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+type EntryChanges struct {
+	Id      string        `json:"id"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// diffFields compares two values of the same struct type field by field,
+// naming fields by their JSON keys. Fields listed in ignore are not compared.
+func diffFields(old, new any, ignore ...string) []FieldChange {
+	oldV := reflect.ValueOf(old)
+	newV := reflect.ValueOf(new)
+	t := oldV.Type()
+
+	var changes []FieldChange
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonFieldName(t.Field(i))
+		if name == "" || slices.Contains(ignore, name) {
+			continue
+		}
+		oldF := oldV.Field(i).Interface()
+		newF := newV.Field(i).Interface()
+		if !reflect.DeepEqual(oldF, newF) {
+			changes = append(changes, FieldChange{Field: name, Old: oldF, New: newF})
+		}
+	}
+	return changes
+}
+
+// jsonFieldName returns the key a struct field is encoded under, or "" if it
+// isn't encoded.
+func jsonFieldName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// copyFields sets the fields of the struct dst points to whose JSON keys are
+// in names to their values in src, which must be of the same type.
+func copyFields(dst, src any, names []string) {
+	dstV := reflect.ValueOf(dst).Elem()
+	srcV := reflect.ValueOf(src)
+	t := dstV.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if slices.Contains(names, jsonFieldName(t.Field(i))) {
+			dstV.Field(i).Set(srcV.Field(i))
+		}
+	}
+}
+
+// hasJSONField reports whether struct type t has a field encoded under name.
+func hasJSONField(t reflect.Type, name string) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if jsonFieldName(t.Field(i)) == name {
+			return true
+		}
+	}
+	return false
+}
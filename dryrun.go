@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// callsPerPhoto returns the API calls needed to hydrate one photo with the
+// current flags. Calls that only happen for some photos count towards the
+// upper bound.
+func callsPerPhoto() (minCalls, maxCalls int) {
+	calls := 1 // flickr.photos.getInfo
+	if !*noSizes {
+		calls++
+	}
+	calls += len(enabledEnrichments)
+
+	conditional := 0
+	if *geoFallback {
+		conditional++
+	}
+	if *publicOnly {
+		conditional++
+	}
+	return calls, calls + conditional
+}
+
+// estimateCost prints how many photos, calls and how much time hydrating each
+// region would take given the current flags.
+func estimateCost(ingests map[string][]string) {
+	minCalls, maxCalls := callsPerPhoto()
+	log.Printf("Each photo takes %d to %d API calls", minCalls, maxCalls)
+
+	var existingCombined map[string]Entry
+	if *appendTo != "" {
+		existingCombined = parseExisting(*appendTo)
+	}
+
+	var regions []string
+	for region := range ingests {
+		regions = append(regions, region)
+	}
+	slices.Sort(regions)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "region\tnew\tmin calls\tmax calls\tETA\t")
+	var totalNew, totalMin, totalMax int
+	for _, region := range regions {
+		existing := existingCombined
+		if existing == nil {
+			existing = parseRegion(region)
+		}
+
+		newIds := 0
+		for _, id := range ingests[region] {
+			entry, ok := existing[id]
+			if !ok || needsRefetch(entry) {
+				newIds++
+			}
+		}
+
+		totalNew += newIds
+		totalMin += newIds * minCalls
+		totalMax += newIds * maxCalls
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t\n", region, newIds, newIds*minCalls, newIds*maxCalls, callDuration(newIds*maxCalls))
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%d\t%s\t\n", totalNew, totalMin, totalMax, callDuration(totalMax))
+	w.Flush()
+
+	if limit := *hourlyCap * numKeys(); limit > 0 && totalMax > limit {
+		log.Printf("This exceeds the hourly cap of %d calls, so the run will pause for the window to roll over", limit)
+	}
+}
+
+// numKeys returns how many API keys a run would rotate across. The dry run
+// doesn't load keys, so it counts -api-keys.
+func numKeys() int {
+	if *apiKeys == "" {
+		return 1
+	}
+	return max(len(parseAPIKeys(*apiKeys)), 1)
+}
+
+// callDuration estimates how long making n calls takes under the rate limit
+// and hourly cap.
+func callDuration(n int) time.Duration {
+	interval := requestInterval
+	if *adaptiveRate {
+		// The slowest -adaptive-rate goes, for an upper bound.
+		interval = time.Duration(float64(time.Second) / *minRate)
+	}
+	d := time.Duration(n) * interval
+	if limit := *hourlyCap * numKeys(); limit > 0 {
+		d = max(d, time.Duration(n/limit)*time.Hour)
+	}
+	return d.Round(time.Second)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Values of -empty-policy, for how empty strings, zero numbers and zero
+// times are written.
+const (
+	emptyAsString = "empty-string" // As they are: "", 0 or 0001-01-01T00:00:00Z
+	emptyAsNull   = "null"
+	emptyOmitted  = "omit"
+)
+
+func checkEmptyPolicy() {
+	switch *emptyPolicy {
+	case emptyAsString, emptyAsNull, emptyOmitted:
+	default:
+		log.Fatalf("-empty-policy must be %s, %s or %s", emptyAsString, emptyAsNull, emptyOmitted)
+	}
+}
+
+// plainEntry is Entry without its MarshalJSON, encoded the usual way whatever
+// -empty-policy is.
+type plainEntry Entry
+
+// MarshalJSON writes an entry's empty fields as -empty-policy says. The id is
+// always written. Fields tagged omitempty are still left out when empty.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	if *emptyPolicy == emptyAsString {
+		return json.Marshal(plainEntry(e))
+	}
+
+	v := reflect.ValueOf(e)
+	t := v.Type()
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := range t.NumField() {
+		name := jsonFieldName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		field := v.Field(i)
+		_, opts, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if strings.Contains(opts, "omitempty") && isEmptyJSON(field) {
+			continue
+		}
+
+		var data []byte
+		if name != "id" && isEmptyScalar(field) {
+			if *emptyPolicy == emptyOmitted {
+				continue
+			}
+			data = []byte("null")
+		} else {
+			var err error
+			if data, err = json.Marshal(field.Interface()); err != nil {
+				return nil, err
+			}
+		}
+
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// isEmptyJSON reports whether omitempty leaves a value out.
+func isEmptyJSON(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isEmptyScalar reports whether a value is an empty string, a zero number or
+// a zero time, which -empty-policy applies to.
+func isEmptyScalar(v reflect.Value) bool {
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.IsZero()
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return v.IsZero()
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEmptyPolicy(t *testing.T) {
+	data, err := os.ReadFile("testdata/entries/no-location.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	entry.DatePosted = time.Time{}
+	entry.RetrievedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	plain, err := json.Marshal(plainEntry(entry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHash := contentHash(entry)
+
+	// Empty strings, a zero number and the zero time cleared above.
+	empty := []string{"description", "latitude", "locationAccuracy", "datePosted"}
+	tests := []struct {
+		policy string
+		want   func(t *testing.T, fields map[string]json.RawMessage)
+	}{
+		{emptyAsString, func(t *testing.T, fields map[string]json.RawMessage) {
+			for _, name := range empty {
+				if _, ok := fields[name]; !ok {
+					t.Errorf("%s is missing, want it written as it is", name)
+				}
+			}
+		}},
+		{emptyAsNull, func(t *testing.T, fields map[string]json.RawMessage) {
+			for _, name := range empty {
+				if string(fields[name]) != "null" {
+					t.Errorf("%s is %s, want null", name, fields[name])
+				}
+			}
+		}},
+		{emptyOmitted, func(t *testing.T, fields map[string]json.RawMessage) {
+			for _, name := range empty {
+				if value, ok := fields[name]; ok {
+					t.Errorf("%s is %s, want it left out", name, value)
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			old := *emptyPolicy
+			defer func() { *emptyPolicy = old }()
+			*emptyPolicy = tt.policy
+
+			got, err := json.Marshal(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.policy == emptyAsString && string(got) != string(plain) {
+				t.Errorf("got %s, want the plain encoding %s", got, plain)
+			}
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(got, &fields); err != nil {
+				t.Fatalf("%s: %s", got, err)
+			}
+			tt.want(t, fields)
+			if string(fields["id"]) != `"1003"` {
+				t.Errorf("id is %s, want \"1003\"", fields["id"])
+			}
+			if string(fields["retrievedAt"]) != `"2024-05-01T12:00:00Z"` {
+				t.Errorf("retrievedAt is %s, want it written as it is", fields["retrievedAt"])
+			}
+
+			var decoded Entry
+			if err := json.Unmarshal(got, &decoded); err != nil {
+				t.Fatalf("reading the entry back: %s", err)
+			}
+			if !reflect.DeepEqual(decoded, entry) {
+				for _, change := range diffFields(entry, decoded) {
+					t.Errorf("%s changed from %v to %v reading the entry back", change.Field, change.Old, change.New)
+				}
+			}
+			if hash := contentHash(entry); hash != wantHash {
+				t.Errorf("content hash is %s, want %s whatever the policy", hash, wantHash)
+			}
+		})
+	}
+}
+
+func TestEmptyPolicyAlwaysWritesId(t *testing.T) {
+	old := *emptyPolicy
+	defer func() { *emptyPolicy = old }()
+	*emptyPolicy = emptyOmitted
+
+	got, err := json.Marshal(Entry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(got, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if string(fields["id"]) != `""` {
+		t.Errorf("got %s, want the empty id written", got)
+	}
+}
+
+func TestEmptyPolicyMatchesBuiltinSchema(t *testing.T) {
+	data, err := os.ReadFile("testdata/entries/no-location.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	entry.DatePosted = time.Time{}
+
+	for _, policy := range []string{emptyAsString, emptyAsNull, emptyOmitted} {
+		t.Run(policy, func(t *testing.T) {
+			old := *emptyPolicy
+			defer func() { *emptyPolicy = old }()
+			*emptyPolicy = policy
+
+			schema := loadSchema("builtin")
+			got, err := json.Marshal(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v any
+			if err := json.Unmarshal(got, &v); err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range schema.validate(v, "") {
+				t.Errorf("-assert-schema builtin rejects it: %s", problem)
+			}
+		})
+	}
+}
+
+func TestBuiltinSchemaStillRequiresId(t *testing.T) {
+	old := *emptyPolicy
+	defer func() { *emptyPolicy = old }()
+	*emptyPolicy = emptyOmitted
+
+	schema := loadSchema("builtin")
+	if !slices.Contains(schema.Required, "id") {
+		t.Errorf("required is %v, want it to still have id", schema.Required)
+	}
+	if problems := schema.validate(map[string]any{"id": nil}, ""); len(problems) == 0 {
+		t.Error("a null id is allowed, want it rejected")
+	}
+}
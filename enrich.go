@@ -0,0 +1,364 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Enrichment is an optional extra call per photo, selected by name with
+// -enrich. Each runs after the base entry is built from getInfo and getSizes,
+// and a failure is noted on the entry rather than failing the photo.
+type Enrichment interface {
+	Name() string
+	Apply(ctx context.Context, photoID string, entry *Entry) error
+}
+
+// presenceChecker is implemented by enrichments that can tell whether an
+// entry already has their fields, which -topup uses to fill in ones added
+// since. Enrichments without it are only topped up after failing.
+type presenceChecker interface {
+	Present(entry *Entry) bool
+}
+
+// enrichments is the registry of enrichments by name. The built in ones are
+// registered as the variable is initialized, rather than in init, so that
+// they are listed in the -enrich usage.
+var enrichments = builtinEnrichments()
+
+// registerEnrichment adds an enrichment to the registry. Forks can call it
+// from an init function to add their own, which -enrich accepts but its
+// usage doesn't list.
+func registerEnrichment(e Enrichment) {
+	if _, ok := enrichments[e.Name()]; ok {
+		panic("enrichment " + e.Name() + " registered twice")
+	}
+	enrichments[e.Name()] = e
+}
+
+// builtinEnrichment adapts the enrichments defined in this file. Some can
+// legitimately come back empty, in which case they look missing and are
+// fetched again by -topup.
+type builtinEnrichment struct {
+	name    string
+	fields  []string // The entry fields it fills in, by JSON name
+	apply   func(context.Context, *Entry) error
+	present func(*Entry) bool
+}
+
+func (e builtinEnrichment) Name() string { return e.name }
+
+func (e builtinEnrichment) Apply(ctx context.Context, photoID string, entry *Entry) error {
+	return e.apply(ctx, entry)
+}
+
+func (e builtinEnrichment) Present(entry *Entry) bool { return e.present(entry) }
+
+func builtinEnrichments() map[string]Enrichment {
+	registry := make(map[string]Enrichment)
+	for _, e := range []builtinEnrichment{
+		{"exif", []string{"exif"}, enrichExif, func(e *Entry) bool { return e.Exif != nil }},
+		{"tags", []string{"tags"}, enrichTags, func(e *Entry) bool { return e.Tags != nil }},
+		{"favorites", []string{"favorites", "favoritedBy"}, enrichFavorites, func(e *Entry) bool { return e.FavoritedBy != nil }},
+		{"comments", []string{"comments"}, enrichComments, func(e *Entry) bool { return e.Comments != nil }},
+		{"groups", []string{"groups"}, enrichGroups, func(e *Entry) bool { return e.Groups != nil }},
+		{"owner", []string{"ownerRealName", "ownerLocation"}, enrichOwner, func(e *Entry) bool { return e.OwnerRealName != "" || e.OwnerLocation != "" }},
+	} {
+		registry[e.name] = e
+	}
+	return registry
+}
+
+var enabledEnrichments []string
+
+// fillingEnrichment returns the built in enrichment that fills in an entry
+// field, by JSON name, if any.
+func fillingEnrichment(field string) (string, bool) {
+	for _, e := range enrichments {
+		if builtin, ok := e.(builtinEnrichment); ok && slices.Contains(builtin.fields, field) {
+			return builtin.name, true
+		}
+	}
+	return "", false
+}
+
+// missingEnrichments returns the enrichments that failed when the entry was
+// hydrated, plus any enabled ones it doesn't have.
+func missingEnrichments(entry *Entry) []string {
+	missing := slices.Clone(entry.PartialEnrichments)
+	for _, name := range enabledEnrichments {
+		checker, ok := enrichments[name].(presenceChecker)
+		if !slices.Contains(missing, name) && ok && !checker.Present(entry) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+func enrichmentNames() []string {
+	var names []string
+	for name := range enrichments {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func parseEnrichments(value string) []string {
+	if value == "" {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := enrichments[name]; !ok {
+			log.Fatalf("Unknown enrichment %q in -enrich, valid options are: %s", name, strings.Join(enrichmentNames(), ", "))
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+type Exif struct {
+	Camera       string `json:"camera,omitempty"`
+	Lens         string `json:"lens,omitempty"`
+	ISO          string `json:"iso,omitempty"`
+	ExposureTime string `json:"exposureTime,omitempty"`
+	FNumber      string `json:"fNumber,omitempty"`
+	FocalLength  string `json:"focalLength,omitempty"`
+}
+
+func enrichExif(ctx context.Context, entry *Entry) error {
+	var resp struct {
+		Photo struct {
+			Camera string `json:"camera"`
+			Exif   []struct {
+				Tag string `json:"tag"`
+				Raw struct {
+					Content string `json:"_content"`
+				} `json:"raw"`
+			} `json:"exif"`
+		} `json:"photo"`
+	}
+	if err := callFlickr(ctx, "flickr.photos.getExif", &resp, map[string]string{"photo_id": entry.Id}); err != nil {
+		return err
+	}
+
+	exif := &Exif{Camera: resp.Photo.Camera}
+	for _, tag := range resp.Photo.Exif {
+		switch tag.Tag {
+		case "LensModel":
+			exif.Lens = tag.Raw.Content
+		case "ISO":
+			exif.ISO = tag.Raw.Content
+		case "ExposureTime":
+			exif.ExposureTime = tag.Raw.Content
+		case "FNumber":
+			exif.FNumber = tag.Raw.Content
+		case "FocalLength":
+			exif.FocalLength = tag.Raw.Content
+		}
+	}
+	entry.Exif = exif
+	return nil
+}
+
+func enrichTags(ctx context.Context, entry *Entry) error {
+	var resp struct {
+		Photo struct {
+			Tags struct {
+				Tag []struct {
+					Raw string `json:"raw"`
+				} `json:"tag"`
+			} `json:"tags"`
+		} `json:"photo"`
+	}
+	if err := callFlickr(ctx, "flickr.tags.getListPhoto", &resp, map[string]string{"photo_id": entry.Id}); err != nil {
+		return err
+	}
+	entry.Tags = nil
+	for _, tag := range resp.Photo.Tags.Tag {
+		entry.Tags = append(entry.Tags, tag.Raw)
+	}
+	return nil
+}
+
+func enrichFavorites(ctx context.Context, entry *Entry) error {
+	var resp struct {
+		Photo struct {
+			Total  flexInt `json:"total"`
+			Person []Owner `json:"person"`
+		} `json:"photo"`
+	}
+	err := callFlickr(ctx, "flickr.photos.getFavorites", &resp, map[string]string{
+		"photo_id": entry.Id,
+		"per_page": fmt.Sprintf("%d", *favSample),
+		"page":     "1",
+	})
+	if err != nil {
+		return err
+	}
+	entry.Favorites = int(resp.Photo.Total)
+	entry.FavoritedBy = make([]Owner, 0, len(resp.Photo.Person))
+	entry.FavoritedBy = append(entry.FavoritedBy, resp.Photo.Person...)
+	return nil
+}
+
+type Comment struct {
+	AuthorNSID string `json:"authorNsid"`
+	AuthorName string `json:"authorName"`
+	Created    string `json:"created"`
+	Text       string `json:"text"`
+}
+
+func enrichComments(ctx context.Context, entry *Entry) error {
+	var resp struct {
+		Comments struct {
+			Comment []struct {
+				Author     string `json:"author"`
+				AuthorName string `json:"authorname"`
+				DateCreate string `json:"datecreate"`
+				Content    string `json:"_content"`
+			} `json:"comment"`
+		} `json:"comments"`
+	}
+	if err := callFlickr(ctx, "flickr.photos.comments.getList", &resp, map[string]string{"photo_id": entry.Id}); err != nil {
+		return err
+	}
+	entry.Comments = nil
+	for _, c := range resp.Comments.Comment {
+		entry.Comments = append(entry.Comments, Comment{
+			AuthorNSID: c.Author,
+			AuthorName: c.AuthorName,
+			Created:    c.DateCreate,
+			Text:       c.Content,
+		})
+	}
+	return nil
+}
+
+type Group struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+}
+
+func enrichGroups(ctx context.Context, entry *Entry) error {
+	var resp struct {
+		Pool []Group `json:"pool"`
+	}
+	if err := callFlickr(ctx, "flickr.photos.getAllContexts", &resp, map[string]string{"photo_id": entry.Id}); err != nil {
+		return err
+	}
+	entry.Groups = resp.Pool
+	return nil
+}
+
+type ownerInfo struct {
+	RealName string
+	Location string
+}
+
+// ownerCache avoids repeating flickr.people.getInfo for owners with many
+// photos.
+var ownerCache = newOwnerLRU()
+
+// ownerLRU remembers the most recently used -owner-cache-size owners, so that
+// a run over many owners doesn't grow without bound.
+type ownerLRU struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Of *ownerCacheEntry, most recently used first
+	stats   CacheStats
+}
+
+func newOwnerLRU() *ownerLRU {
+	return &ownerLRU{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+type ownerCacheEntry struct {
+	nsid  string
+	owner ownerInfo
+}
+
+// CacheStats counts how a cache was used over the run.
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+func (c *ownerLRU) get(nsid string) (ownerInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[nsid]
+	if !ok {
+		c.stats.Misses++
+		return ownerInfo{}, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*ownerCacheEntry).owner, true
+}
+
+// add remembers an owner, forgetting the least recently used beyond size,
+// where 0 is no limit.
+func (c *ownerLRU) add(nsid string, owner ownerInfo, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[nsid]; ok {
+		elem.Value.(*ownerCacheEntry).owner = owner
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[nsid] = c.order.PushFront(&ownerCacheEntry{nsid: nsid, owner: owner})
+	for size > 0 && c.order.Len() > size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*ownerCacheEntry).nsid)
+		c.stats.Evictions++
+	}
+}
+
+// Stats returns how the cache has been used so far, or nil if it hasn't.
+func (c *ownerLRU) Stats() *CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats == (CacheStats{}) {
+		return nil
+	}
+	stats := c.stats
+	return &stats
+}
+
+func enrichOwner(ctx context.Context, entry *Entry) error {
+	owner, ok := ownerCache.get(entry.OwnerNSID)
+
+	if !ok {
+		var resp struct {
+			Person struct {
+				RealName struct {
+					Content string `json:"_content"`
+				} `json:"realname"`
+				Location struct {
+					Content string `json:"_content"`
+				} `json:"location"`
+			} `json:"person"`
+		}
+		if err := callFlickr(ctx, "flickr.people.getInfo", &resp, map[string]string{"user_id": entry.OwnerNSID}); err != nil {
+			return err
+		}
+		owner = ownerInfo{RealName: resp.Person.RealName.Content, Location: resp.Person.Location.Content}
+
+		ownerCache.add(entry.OwnerNSID, owner, *ownerCacheSize)
+	}
+
+	entry.OwnerRealName = owner.RealName
+	entry.OwnerLocation = owner.Location
+	return nil
+}
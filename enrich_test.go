@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// fakeEnrichment records the photos it is applied to, failing with err.
+type fakeEnrichment struct {
+	name    string
+	err     error
+	applied []string
+}
+
+func (e *fakeEnrichment) Name() string { return e.name }
+
+func (e *fakeEnrichment) Apply(ctx context.Context, photoID string, entry *Entry) error {
+	e.applied = append(e.applied, photoID)
+	if e.err != nil {
+		return e.err
+	}
+	entry.Tags = append(entry.Tags, e.name)
+	return nil
+}
+
+func TestRegisteredEnrichment(t *testing.T) {
+	useFixtures(t)
+	ok := &fakeEnrichment{name: "fake"}
+	failing := &fakeEnrichment{name: "fake-failing", err: errors.New("unavailable")}
+	for _, e := range []*fakeEnrichment{ok, failing} {
+		registerEnrichment(e)
+		defer delete(enrichments, e.Name())
+	}
+	old := enabledEnrichments
+	defer func() { enabledEnrichments = old }()
+	enabledEnrichments = parseEnrichments("fake, fake-failing")
+
+	entry, skip, err := createEntry(context.Background(), "1001")
+	if err != nil || skip != NotSkipped {
+		t.Fatalf("got skip %q and error %v, want the entry", skip, err)
+	}
+	for _, e := range []*fakeEnrichment{ok, failing} {
+		if !slices.Equal(e.applied, []string{"1001"}) {
+			t.Errorf("%s was applied to %v, want [1001]", e.name, e.applied)
+		}
+	}
+	if !slices.Contains(entry.Tags, "fake") {
+		t.Errorf("tags are %v, want the fake enrichment's fake", entry.Tags)
+	}
+	if !slices.Equal(entry.PartialEnrichments, []string{"fake-failing"}) {
+		t.Errorf("partial enrichments are %v, want [fake-failing]", entry.PartialEnrichments)
+	}
+}
+
+func TestOwnerLRU(t *testing.T) {
+	c := newOwnerLRU()
+	if c.Stats() != nil {
+		t.Errorf("unused cache has stats %+v, want none", c.Stats())
+	}
+	c.add("a", ownerInfo{RealName: "A"}, 2)
+	c.add("b", ownerInfo{RealName: "B"}, 2)
+	// Using a makes b the least recently used, so the one forgotten.
+	if owner, ok := c.get("a"); !ok || owner.RealName != "A" {
+		t.Fatalf("got %+v, %v, want A", owner, ok)
+	}
+	c.add("c", ownerInfo{RealName: "C"}, 2)
+	if _, ok := c.get("b"); ok {
+		t.Error("b is still cached, want it evicted")
+	}
+	for _, nsid := range []string{"a", "c"} {
+		if _, ok := c.get(nsid); !ok {
+			t.Errorf("%s isn't cached", nsid)
+		}
+	}
+	if want := (CacheStats{Hits: 3, Misses: 1, Evictions: 1}); *c.Stats() != want {
+		t.Errorf("stats are %+v, want %+v", *c.Stats(), want)
+	}
+}
+
+func TestOwnerLRUUnbounded(t *testing.T) {
+	c := newOwnerLRU()
+	for _, nsid := range []string{"a", "b", "c", "a"} {
+		c.add(nsid, ownerInfo{}, 0)
+	}
+	if c.order.Len() != 3 {
+		t.Errorf("cache has %d owners, want 3", c.order.Len())
+	}
+}
+
+func TestFailedEnrichmentKeepsEntry(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	httpClient = overrideDoer{next: httpClient, bodies: map[string]string{
+		"flickr.photos.getExif": `{"stat":"fail","code":2,"message":"Permission denied"}`,
+	}}
+	old := enabledEnrichments
+	defer func() { enabledEnrichments = old }()
+	enabledEnrichments = []string{"exif"}
+
+	var buf bytes.Buffer
+	out := newEntryWriter(&buf)
+	stats, err := processRegion(context.Background(), "region", []string{"1001"}, out, make(map[string]Entry))
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := decodeEntries(buf.Bytes())
+	if stats.Failed != 0 || len(entries) != 1 {
+		t.Fatalf("%d failed and wrote %v, want the entry written", stats.Failed, entryIds(entries))
+	}
+	if entry := entries[0]; entry.Exif != nil || !slices.Equal(entry.PartialEnrichments, []string{"exif"}) {
+		t.Errorf("entry has exif %+v and partial enrichments %v, want no exif and [exif]", entry.Exif, entry.PartialEnrichments)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrorReport is a failure to hydrate a photo, as written to -errors-file for
+// tools that need to tell failures apart without parsing logs.
+type ErrorReport struct {
+	Region    string    `json:"region"`
+	Id        string    `json:"id"`
+	Method    string    `json:"method,omitempty"` // The Flickr method that failed, if it was a call
+	Code      int       `json:"code,omitempty"`   // Flickr's error code, or the HTTP status
+	Message   string    `json:"message"`
+	Retryable bool      `json:"retryable"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// errorReports writes to -errors-file, and is nil if it isn't set.
+var errorReports *lazyEncoder
+
+func reportError(region, id string, err error) {
+	if errorReports == nil {
+		return
+	}
+	report := ErrorReport{
+		Region:    region,
+		Id:        id,
+		Message:   err.Error(),
+		Retryable: isRetryable(err),
+		Timestamp: time.Now().UTC(),
+	}
+	var flickrErr *FlickrError
+	var statusErr *HTTPStatusError
+	if errors.As(err, &flickrErr) {
+		report.Method, report.Code, report.Message = flickrErr.Method, flickrErr.Code, flickrErr.Message
+	} else if errors.As(err, &statusErr) {
+		report.Method, report.Code = statusErr.Method, statusErr.StatusCode
+	}
+	errorReports.Encode(report)
+}
+
+// isRetryable reports whether hydrating a photo again later might work.
+// Unlike isTransient, this includes photos Flickr hasn't finished processing
+// and photos that took too long.
+func isRetryable(err error) bool {
+	return isTransient(err) || errors.Is(err, errMediaNotReady) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingDoer fails each call about the photos in responses with the status
+// and body given, and passes the rest on to next.
+type failingDoer struct {
+	next      Doer
+	responses map[string]struct {
+		status int
+		body   string
+	}
+}
+
+func (d failingDoer) Do(req *http.Request) (*http.Response, error) {
+	r, ok := d.responses[req.URL.Query().Get("photo_id")]
+	if !ok {
+		return d.next.Do(req)
+	}
+	return &http.Response{
+		StatusCode: r.status,
+		Body:       io.NopCloser(strings.NewReader(r.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestErrorsFile(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	useRetryBudget(t, 0, 0, 0)
+	httpClient = failingDoer{next: httpClient, responses: map[string]struct {
+		status int
+		body   string
+	}{
+		"1001": {http.StatusOK, `{"stat":"fail","code":100,"message":"Invalid API Key (Key has invalid format)"}`},
+		"1003": {http.StatusBadGateway, ""},
+	}}
+	fname := filepath.Join(t.TempDir(), "errors.ndjson")
+	old := errorReports
+	defer func() { errorReports = old }()
+	errorReports = &lazyEncoder{fname: fname}
+
+	var buf bytes.Buffer
+	out := newEntryWriter(&buf)
+	if _, err := processRegion(context.Background(), "region", []string{"1001", "1003"}, out, make(map[string]Entry)); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	errorReports.Close()
+
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []ErrorReport
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var report ErrorReport
+		if err := dec.Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Timestamp.IsZero() {
+			t.Errorf("%s has no timestamp", report.Id)
+		}
+		got = append(got, report)
+	}
+
+	tests := []struct {
+		name string
+		want ErrorReport
+	}{
+		{"stat fail", ErrorReport{
+			Region:    "region",
+			Id:        "1001",
+			Method:    "flickr.photos.getInfo",
+			Code:      100,
+			Message:   "Invalid API Key (Key has invalid format)",
+			Retryable: false,
+		}},
+		{"HTTP error", ErrorReport{
+			Region:    "region",
+			Id:        "1003",
+			Method:    "flickr.photos.getInfo",
+			Code:      http.StatusBadGateway,
+			Message:   "flickr.photos.getInfo: HTTP status 502",
+			Retryable: true,
+		}},
+	}
+	if len(got) != len(tests) {
+		t.Fatalf("got %d reports, want %d:\n%s", len(got), len(tests), data)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := got[i]
+			report.Timestamp = tt.want.Timestamp
+			if report != tt.want {
+				t.Errorf("got %+v, want %+v", report, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// fieldMissing reports whether the value at a dotted path of JSON keys, such
+// as exif.iso, is absent or zero. A nil pointer or map anywhere along the path
+// counts as missing.
+func fieldMissing(v any, path string) bool {
+	value := reflect.ValueOf(v)
+	for _, name := range strings.Split(path, ".") {
+		for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+			if value.IsNil() {
+				return true
+			}
+			value = value.Elem()
+		}
+		switch value.Kind() {
+		case reflect.Struct:
+			next, ok := jsonField(value, name)
+			if !ok {
+				return true
+			}
+			value = next
+		case reflect.Map:
+			value = value.MapIndex(reflect.ValueOf(name))
+			if !value.IsValid() {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return value.IsZero()
+}
+
+func jsonField(value reflect.Value, name string) (reflect.Value, bool) {
+	f, ok := jsonStructField(value.Type(), name)
+	if !ok {
+		return reflect.Value{}, false
+	}
+	return value.FieldByIndex(f.Index), true
+}
+
+// checkFieldPath exits if a dotted path names a field entries can't have,
+// following structs and pointers to them, or one that goes through a list,
+// which has no one value to check. Paths into maps can't be checked. A field
+// only an enrichment fills in needs that enrichment in -enrich, since
+// otherwise every entry would look like it is missing.
+func checkFieldPath(path string) {
+	names := strings.Split(path, ".")
+	if enrichment, ok := fillingEnrichment(names[0]); ok && !slices.Contains(enabledEnrichments, enrichment) {
+		log.Fatalf("Invalid -refetch-if-missing %q: %s is only filled in by -enrich %s", path, names[0], enrichment)
+	}
+	t := reflect.TypeFor[Entry]()
+	for i, name := range names {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Slice {
+			log.Fatalf("Invalid -refetch-if-missing %q: %s is a list", path, names[i-1])
+		}
+		if t.Kind() == reflect.Map {
+			return
+		}
+		if t.Kind() != reflect.Struct {
+			log.Fatalf("Invalid -refetch-if-missing %q: %s has no fields", path, names[i-1])
+		}
+		f, ok := jsonStructField(t, name)
+		if !ok {
+			log.Fatalf("Invalid -refetch-if-missing %q: no field %q", path, name)
+		}
+		t = f.Type
+	}
+}
+
+func jsonStructField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		if jsonFieldName(t.Field(i)) == name {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
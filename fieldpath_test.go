@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFieldMissing(t *testing.T) {
+	tests := []struct {
+		path  string
+		entry Entry
+		want  bool
+	}{
+		{"title", Entry{Title: "Tarn"}, false},
+		{"title", Entry{}, true},
+		{"views", Entry{Views: 0}, true},
+		{"exif.iso", Entry{}, true},
+		{"exif.iso", Entry{Exif: &Exif{Camera: "Fujifilm X-T4"}}, true},
+		{"exif.iso", Entry{Exif: &Exif{ISO: "160"}}, false},
+		{"locationParts.country.name", Entry{LocationParts: &Places{Locality: &Place{Name: "Keswick"}}}, true},
+		{"locationParts.country.name", Entry{LocationParts: &Places{Country: &Place{Name: "United Kingdom"}}}, false},
+		{"local.curatorNote", Entry{Local: map[string]any{"curatorNote": "Cairn"}}, false},
+		{"local.curatorNote", Entry{Local: map[string]any{"other": "x"}}, true},
+		{"local.curatorNote", Entry{}, true},
+	}
+	for _, tt := range tests {
+		if got := fieldMissing(tt.entry, tt.path); got != tt.want {
+			entryJSON, _ := json.Marshal(tt.entry)
+			t.Errorf("%s missing from %s: %v, want %v", tt.path, entryJSON, got, tt.want)
+		}
+	}
+}
+
+func TestRefetchIfMissingNestedField(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	oldMissing, oldEnrichments := *refetchMissing, enabledEnrichments
+	defer func() { *refetchMissing, enabledEnrichments = oldMissing, oldEnrichments }()
+	*refetchMissing = "exif.iso"
+	enabledEnrichments = []string{"exif"}
+
+	// Both entries are fresh, but 1001's exif was fetched before it had an
+	// ISO.
+	retrievedAt := time.Now().UTC()
+	existing := map[string]Entry{
+		"1001": {Id: "1001", Region: "region", RetrievedAt: retrievedAt, Exif: &Exif{Camera: "Fujifilm X-T4"}},
+		"1006": {Id: "1006", Region: "region", RetrievedAt: retrievedAt, Exif: &Exif{ISO: "200"}},
+	}
+	var buf bytes.Buffer
+	out := newEntryWriter(&buf)
+	stats, err := processRegion(context.Background(), "region", []string{"1001", "1006"}, out, existing)
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Refetched != 1 {
+		t.Errorf("refetched %d entries, want 1", stats.Refetched)
+	}
+	var got Entry
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%s: %s", buf.Bytes(), err)
+	}
+	if got.Id != "1001" || got.Exif == nil || got.Exif.ISO != "160" {
+		t.Errorf("wrote %s, want 1001 refetched with its ISO", buf.Bytes())
+	}
+}
+
+func TestCheckFieldPathRejectsUnknown(t *testing.T) {
+	old := enabledEnrichments
+	defer func() { enabledEnrichments = old }()
+	enabledEnrichments = []string{"exif"}
+	expectFatal(t, `Invalid -refetch-if-missing "exif.shutter": no field "shutter"`, func() { checkFieldPath("exif.shutter") })
+}
+
+func TestCheckFieldPathNeedsEnrichment(t *testing.T) {
+	old := enabledEnrichments
+	defer func() { enabledEnrichments = old }()
+	enabledEnrichments = nil
+	expectFatal(t, "exif is only filled in by -enrich exif", func() { checkFieldPath("exif.iso") })
+}
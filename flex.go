@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Flickr is inconsistent about whether numeric-ish fields are JSON strings or
+// numbers, depending on the method. These types accept either.
+
+// flexString decodes a JSON string or number as a string. null leaves it
+// unchanged.
+type flexString string
+
+func (s *flexString) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*s = flexString(str)
+		return nil
+	}
+	*s = flexString(data)
+	return nil
+}
+
+// flexInt decodes a JSON number or a string containing one as an int. An empty
+// string decodes as zero, and null, as -empty-policy null writes it, leaves it
+// unchanged. It always encodes as a number.
+type flexInt int
+
+func (n *flexInt) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		if str == "" {
+			*n = 0
+			return nil
+		}
+		data = []byte(str)
+	}
+	v, err := strconv.Atoi(string(data))
+	if err != nil {
+		return err
+	}
+	*n = flexInt(v)
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFlexInt(t *testing.T) {
+	tests := []struct {
+		json    string
+		want    flexInt
+		wantErr bool
+	}{
+		{`16`, 16, false},
+		{`"16"`, 16, false},
+		{`-90`, -90, false},
+		{`"-90"`, -90, false},
+		{`""`, 0, false},
+		{`null`, 7, false}, // Left as it was
+		{`"sixteen"`, 0, true},
+		{`1.5`, 0, true},
+	}
+	for _, tt := range tests {
+		n := flexInt(7)
+		err := json.Unmarshal([]byte(tt.json), &n)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("decoding %s: got error %v, want one: %v", tt.json, err, tt.wantErr)
+			continue
+		}
+		if err == nil && n != tt.want {
+			t.Errorf("decoding %s: got %d, want %d", tt.json, n, tt.want)
+		}
+	}
+	if data, err := json.Marshal(flexInt(16)); err != nil || string(data) != "16" {
+		t.Errorf("encoded as %s, %v, want the number 16", data, err)
+	}
+}
+
+func TestFlexIntFields(t *testing.T) {
+	forms := map[string]func(string) string{
+		"strings": func(n string) string { return `"` + n + `"` },
+		"numbers": func(n string) string { return n },
+	}
+	for name, num := range forms {
+		t.Run(name, func(t *testing.T) {
+			var location flickrLocation
+			if err := json.Unmarshal([]byte(`{"accuracy":`+num("16")+`}`), &location); err != nil || location.Accuracy != 16 {
+				t.Errorf("location accuracy decoded as %d, %v, want 16", location.Accuracy, err)
+			}
+			var entry Entry
+			if err := json.Unmarshal([]byte(`{"locationAccuracy":`+num("16")+`}`), &entry); err != nil || entry.LocationAccuracy != 16 {
+				t.Errorf("entry locationAccuracy decoded as %d, %v, want 16", entry.LocationAccuracy, err)
+			}
+			var results searchResults
+			data := `{"total":` + num("3") + `,"photo":[{"accuracy":` + num("16") + `,"content_type":` + num("1") + `,"views":` + num("812") + `}]}`
+			if err := json.Unmarshal([]byte(data), &results); err != nil {
+				t.Fatal(err)
+			}
+			if photo := results.Photo[0]; results.Total != 3 || photo.Accuracy != 16 || photo.ContentType != 1 || photo.Views != 812 {
+				t.Errorf("search results decoded as %+v, want total 3, accuracy 16, content type 1 and 812 views", results)
+			}
+		})
+	}
+}
+
+func TestFlexIntGetInfoFields(t *testing.T) {
+	info, err := os.ReadFile("testdata/entries/responses/flickr.photos.getInfo.1001.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	numbers := strings.NewReplacer(`"content_type":"1"`, `"content_type":1`, `"views":"812"`, `"views":812`, `"accuracy":"16"`, `"accuracy":16`)
+	for name, body := range map[string]string{"strings": string(info), "numbers": numbers.Replace(string(info))} {
+		t.Run(name, func(t *testing.T) {
+			useFixtures(t)
+			httpClient = overrideDoer{next: httpClient, bodies: map[string]string{"flickr.photos.getInfo": body}}
+			entry, _, err := createEntry(context.Background(), "1001")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.ContentType != 1 || entry.Views != 812 || entry.LocationAccuracy != 16 {
+				t.Errorf("got content type %d, %d views and accuracy %d, want 1, 812 and 16", entry.ContentType, entry.Views, entry.LocationAccuracy)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxBBoxDepth bounds how many times generate-ingest splits a bounding box.
+const maxBBoxDepth = 6
+
+type bbox struct {
+	minLng, minLat, maxLng, maxLat float64
+}
+
+func parseBBox(value string) (bbox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return bbox{}, fmt.Errorf("expected minLng,minLat,maxLng,maxLat")
+	}
+	var coords [4]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return bbox{}, err
+		}
+		coords[i] = v
+	}
+	b := bbox{coords[0], coords[1], coords[2], coords[3]}
+	if b.minLng >= b.maxLng || b.minLat >= b.maxLat {
+		return bbox{}, fmt.Errorf("minimums must be less than maximums")
+	}
+	return b, nil
+}
+
+func (b bbox) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.minLng, b.minLat, b.maxLng, b.maxLat)
+}
+
+func (b bbox) quadrants() []bbox {
+	midLng := (b.minLng + b.maxLng) / 2
+	midLat := (b.minLat + b.maxLat) / 2
+	return []bbox{
+		{b.minLng, b.minLat, midLng, midLat},
+		{midLng, b.minLat, b.maxLng, midLat},
+		{b.minLng, midLat, midLng, b.maxLat},
+		{midLng, midLat, b.maxLng, b.maxLat},
+	}
+}
+
+// runGenerateIngest writes the ids of geotagged photos within a bounding box
+// to a region's ingest file, appending to any ids already there.
+func runGenerateIngest(args []string) {
+	fs := flag.NewFlagSet("generate-ingest", flag.ExitOnError)
+	bboxFlag := fs.String("bbox", "", "Bounding box to search, as minLng,minLat,maxLng,maxLat")
+	region := fs.String("region", "", "Region whose ingest file to write")
+	subdivide := fs.Bool("subdivide", true, "Split the box into quadrants when it matches more photos than one search returns")
+	fs.Parse(args)
+
+	if *region == "" {
+		log.Fatal("generate-ingest requires -region")
+	}
+	box, err := parseBBox(*bboxFlag)
+	if err != nil {
+		log.Fatalf("Invalid -bbox %q: %s", *bboxFlag, err)
+	}
+
+	loadAPIKey()
+	ctx := context.Background()
+
+	if err := os.MkdirAll(*ingestDir, dirMode); err != nil {
+		log.Fatal(err)
+	}
+	fname := ingestFile(*region)
+	seen := make(map[string]bool)
+	for _, id := range parseRegionIngest(*region) {
+		seen[id] = true
+	}
+
+	outF := openOutput(fname)
+	defer outF.Close()
+	enc := json.NewEncoder(outF)
+	added := 0
+	err = searchBBox(ctx, box, *subdivide, 0, func(id string) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		if err := enc.Encode(id); err != nil {
+			log.Fatal(err)
+		}
+		added++
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Added %d ids to %s", added, fname)
+}
+
+func searchBBox(ctx context.Context, box bbox, subdivide bool, depth int, fn func(id string)) error {
+	params := url.Values{}
+	params.Set("bbox", box.String())
+	params.Set("has_geo", "1")
+
+	for page := 1; ; page++ {
+		results, err := searchPage(ctx, params, "", page)
+		if err != nil {
+			return err
+		}
+
+		if page == 1 && subdivide && depth < maxBBoxDepth && int(results.Total) > searchResultCap {
+			log.Printf("Box %s matches %d photos, subdividing", box, results.Total)
+			for _, quadrant := range box.quadrants() {
+				if err := searchBBox(ctx, quadrant, subdivide, depth+1, fn); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		for _, photo := range results.Photo {
+			fn(photo.Id)
+		}
+		if page >= results.Pages {
+			return nil
+		}
+		if page*searchPerPage >= searchResultCap {
+			log.Printf("Warning: box %s matched %d photos but Flickr only returns the first %d", box, results.Total, searchResultCap)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// parseCoordinates fills in an entry's numeric coordinates from the strings
+// Flickr returned, rounding both forms to -coord-precision decimal places if
+// it is set. Five decimal places is roughly one metre.
+func parseCoordinates(entry *Entry) {
+	entry.LatitudeF = nil
+	entry.LongitudeF = nil
+	lat, err := strconv.ParseFloat(entry.Latitude, 64)
+	if err != nil {
+		return
+	}
+	lng, err := strconv.ParseFloat(entry.Longitude, 64)
+	if err != nil {
+		return
+	}
+	if lat == 0 && lng == 0 {
+		// Flickr's search extras report photos without a location as 0,0.
+		return
+	}
+
+	if *coordPrecision >= 0 {
+		lat = roundTo(lat, *coordPrecision)
+		lng = roundTo(lng, *coordPrecision)
+		entry.Latitude = strconv.FormatFloat(lat, 'f', -1, 64)
+		entry.Longitude = strconv.FormatFloat(lng, 'f', -1, 64)
+	}
+
+	entry.LatitudeF = &lat
+	entry.LongitudeF = &lng
+}
+
+// roundTo rounds v to the given number of decimal places, with halves rounded
+// away from zero.
+func roundTo(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
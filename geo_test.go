@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestParseCoordinatesPrecision(t *testing.T) {
+	tests := []struct {
+		precision          int
+		lat, lng           string
+		wantLat, wantLng   string
+		wantLatF, wantLngF float64
+	}{
+		{-1, "54.526213", "-3.016547", "54.526213", "-3.016547", 54.526213, -3.016547},
+		{5, "54.526213", "-3.016547", "54.52621", "-3.01655", 54.52621, -3.01655},
+		{2, "54.526213", "-3.016547", "54.53", "-3.02", 54.53, -3.02},
+		{0, "54.526213", "-3.516547", "55", "-4", 55, -4},
+		// Halves round away from zero, including negative ones.
+		{1, "-33.85", "151.25", "-33.9", "151.3", -33.9, 151.3},
+		// Trailing zeros aren't written.
+		{3, "-33.8", "151.2", "-33.8", "151.2", -33.8, 151.2},
+	}
+	old := *coordPrecision
+	defer func() { *coordPrecision = old }()
+	for _, tt := range tests {
+		*coordPrecision = tt.precision
+		entry := Entry{Latitude: tt.lat, Longitude: tt.lng}
+		parseCoordinates(&entry)
+		if entry.LatitudeF == nil || entry.LongitudeF == nil {
+			t.Errorf("%s,%s at precision %d has no coordinates", tt.lat, tt.lng, tt.precision)
+			continue
+		}
+		if entry.Latitude != tt.wantLat || entry.Longitude != tt.wantLng || *entry.LatitudeF != tt.wantLatF || *entry.LongitudeF != tt.wantLngF {
+			t.Errorf("%s,%s at precision %d: got %s,%s (%v,%v), want %s,%s", tt.lat, tt.lng, tt.precision,
+				entry.Latitude, entry.Longitude, *entry.LatitudeF, *entry.LongitudeF, tt.wantLat, tt.wantLng)
+		}
+	}
+}
+
+func TestParseCoordinatesUnlocated(t *testing.T) {
+	for _, coords := range [][2]string{{"", ""}, {"0", "0"}, {"54.5", ""}, {"north", "west"}} {
+		entry := Entry{Latitude: coords[0], Longitude: coords[1]}
+		parseCoordinates(&entry)
+		if entry.LatitudeF != nil || entry.LongitudeF != nil {
+			t.Errorf("%q,%q parsed as coordinates, want none", coords[0], coords[1])
+		}
+	}
+}
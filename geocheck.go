@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// LatLng is a coordinate in degrees.
+type LatLng struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// ingestLine is an ingest line that gives where the photo is expected to be
+// as well as its id, such as {"id":"123","expected":{"latitude":54.5,"longitude":-3.0}}.
+type ingestLine struct {
+	Id       string  `json:"id"`
+	Expected *LatLng `json:"expected"`
+}
+
+// expectedCoords holds the coordinates ingest files expect photos at, by id.
+var expectedCoords = make(map[string]LatLng)
+
+// parseIngestLine parses an ingest line, which is either a bare id or an
+// ingestLine, recording any expected coordinates.
+func parseIngestLine(line json.RawMessage) (string, error) {
+	var id string
+	if err := json.Unmarshal(line, &id); err == nil {
+		return id, nil
+	}
+	var enriched ingestLine
+	if err := json.Unmarshal(line, &enriched); err != nil {
+		return "", fmt.Errorf("expected an id or an object with an id: %w", err)
+	}
+	if enriched.Expected != nil {
+		expectedCoords[enriched.Id] = *enriched.Expected
+	}
+	return enriched.Id, nil
+}
+
+type GeoMismatch struct {
+	Id         string  `json:"id"`
+	Expected   LatLng  `json:"expected"`
+	Actual     LatLng  `json:"actual"`
+	DistanceKm float64 `json:"distanceKm"`
+}
+
+// checkExpectedCoords compares an entry's coordinates with those its ingest
+// file expected, returning the mismatch if they are further apart than
+// -geo-mismatch-km. Entries without coordinates, or without expected ones, are
+// never a mismatch.
+func checkExpectedCoords(entry Entry) (GeoMismatch, bool) {
+	expected, ok := expectedCoords[entry.Id]
+	if !ok || entry.LatitudeF == nil || entry.LongitudeF == nil {
+		return GeoMismatch{}, false
+	}
+	actual := LatLng{Latitude: *entry.LatitudeF, Longitude: *entry.LongitudeF}
+	d := haversineKm(expected, actual)
+	if d <= *geoMismatchKm {
+		return GeoMismatch{}, false
+	}
+	return GeoMismatch{Id: entry.Id, Expected: expected, Actual: actual, DistanceKm: d}, true
+}
+
+const earthRadiusKm = 6371.0088
+
+// haversineKm returns the great-circle distance between two points.
+func haversineKm(a, b LatLng) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLng := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLng/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
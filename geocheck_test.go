@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHaversineKm(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b LatLng
+		want float64
+	}{
+		{"same point", LatLng{54.5262, -3.0165}, LatLng{54.5262, -3.0165}, 0},
+		{"a degree of latitude", LatLng{0, 0}, LatLng{1, 0}, 111.195},
+		{"London to Paris", LatLng{51.5074, -0.1278}, LatLng{48.8566, 2.3522}, 343.56},
+		{"across the antimeridian", LatLng{0, 179.5}, LatLng{0, -179.5}, 111.195},
+	}
+	for _, tt := range tests {
+		if got := haversineKm(tt.a, tt.b); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("%s: got %.3fkm, want %.3fkm", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGeoMismatch(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	oldCoords := expectedCoords
+	defer func() { expectedCoords = oldCoords }()
+	expectedCoords = make(map[string]LatLng)
+
+	// 1001 is about 10m from where its line expects it, and 1006 is in the
+	// Lake District rather than in London.
+	fname := filepath.Join(*ingestDir, "region.ndjson")
+	writeFile(t, fname,
+		`{"id":"1001","expected":{"latitude":54.5263,"longitude":-3.0166}}`,
+		`{"id":"1006","expected":{"latitude":51.5074,"longitude":-0.1278}}`,
+		`"1003"`)
+	ids := parseIngest(fname)
+
+	var buf bytes.Buffer
+	out := newEntryWriter(&buf)
+	stats, err := processRegion(context.Background(), "region", ids, out, make(map[string]Entry))
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 2 || stats.Skipped[SkipGeoMismatch] != 1 {
+		t.Errorf("wrote %d and skipped %d as mismatches, want 1001 and 1003 written and 1006 skipped", stats.Written, stats.Skipped[SkipGeoMismatch])
+	}
+
+	data, err := os.ReadFile(outPath("region.geo-mismatch.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mismatch GeoMismatch
+	if err := json.Unmarshal(data, &mismatch); err != nil {
+		t.Fatal(err)
+	}
+	if mismatch.Id != "1006" || mismatch.Actual != (LatLng{54.5271, -3.0168}) || mismatch.DistanceKm < 300 {
+		t.Errorf("recorded %+v, want 1006 about 350km out", mismatch)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runProgress tracks how far the run has got for the -metrics-addr endpoints,
+// which read it from the server's goroutines.
+type runProgress struct {
+	ready atomic.Bool // Set once preflight has passed
+
+	mu        sync.Mutex
+	startedAt time.Time
+	total     int // Ids across all regions
+	done      int // Ids finished with in earlier regions
+	region    string
+	size      int // Ids in the current region
+	position  int // Ids finished with in the current region
+	current   RegionStats
+	totals    *RegionStats // Of earlier regions
+}
+
+var progress = &runProgress{
+	current: RegionStats{Skipped: make(map[SkipReason]int)},
+	totals:  newRegionStats(),
+}
+
+type ProgressReport struct {
+	Region      string       `json:"region"`
+	Processed   int          `json:"processed"`
+	Total       int          `json:"total"`
+	RegionStats RegionStats  `json:"regionStats"`
+	Totals      *RegionStats `json:"totals"`     // Including the current region
+	ETASeconds  *float64     `json:"etaSeconds"` // Null until an id has been finished with
+}
+
+// start records how many ids the run has to get through.
+func (p *runProgress) start(ingests map[string][]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.startedAt = time.Now()
+	for _, ids := range ingests {
+		p.total += len(ids)
+	}
+}
+
+// startRegion records that a region of n ids has been started on.
+func (p *runProgress) startRegion(region string, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.region = region
+	p.size = n
+	p.position = 0
+	p.current = RegionStats{Skipped: make(map[SkipReason]int)}
+}
+
+// update records that the first position ids of the current region are
+// finished with. stats is copied, so the caller can carry on changing it.
+func (p *runProgress) update(position int, stats *RegionStats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.position = position
+	p.current = *stats
+	p.current.Skipped = maps.Clone(stats.Skipped)
+}
+
+// finishRegion adds the current region's final stats to the totals.
+func (p *runProgress) finishRegion(stats *RegionStats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += p.size
+	p.size, p.position = 0, 0
+	p.totals.add(stats)
+	p.current = RegionStats{Skipped: make(map[SkipReason]int)}
+}
+
+func (p *runProgress) report() ProgressReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	totals := newRegionStats()
+	totals.add(p.totals)
+	totals.add(&p.current)
+	r := ProgressReport{
+		Region:      p.region,
+		Processed:   p.done + p.position,
+		Total:       p.total,
+		RegionStats: p.current,
+		Totals:      totals,
+	}
+	if r.Processed > 0 {
+		// A rough guess, since ids that are already hydrated go by far faster
+		// than those that aren't.
+		perId := time.Since(p.startedAt).Seconds() / float64(r.Processed)
+		eta := perId * float64(r.Total-r.Processed)
+		r.ETASeconds = &eta
+	}
+	return r
+}
+
+// serveHealth serves /healthz, which is OK as long as the process is up,
+// /readyz, which is OK once preflight has passed, and /progress on addr.
+func serveHealth(addr string) {
+	handler := healthHandler()
+	go func() {
+		if err := http.ListenAndServe(addr, handler); err != nil {
+			log.Fatalf("Serving -metrics-addr: %s", err)
+		}
+	}()
+	log.Printf("Serving health checks and progress on %s", addr)
+}
+
+func healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !progress.ready.Load() {
+			http.Error(w, "preflight has not passed", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /progress", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(progress.report()); err != nil {
+			log.Printf("Writing /progress: %s", err)
+		}
+	})
+	return mux
+}
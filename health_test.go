@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// gatedDoer holds requests about photo until release is closed, first
+// sending on reached.
+type gatedDoer struct {
+	next    Doer
+	photo   string
+	reached chan struct{}
+	release chan struct{}
+}
+
+func (d gatedDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.URL.Query().Get("photo_id") == d.photo {
+		select {
+		case d.reached <- struct{}{}:
+		default:
+		}
+		<-d.release
+	}
+	return d.next.Do(req)
+}
+
+func TestHealthEndpointsMidRun(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	oldProgress := progress
+	defer func() { progress = oldProgress }()
+	progress = &runProgress{
+		current: RegionStats{Skipped: make(map[SkipReason]int)},
+		totals:  newRegionStats(),
+	}
+	doer := gatedDoer{next: httpClient, photo: "1003", reached: make(chan struct{}, 1), release: make(chan struct{})}
+	httpClient = doer
+	server := httptest.NewServer(healthHandler())
+	defer server.Close()
+
+	ids := []string{"1001", "1003", "1006"}
+	progress.start(map[string][]string{"region": ids})
+	done := make(chan error)
+	go func() {
+		var buf bytes.Buffer
+		out := newEntryWriter(&buf)
+		_, err := processRegion(context.Background(), "region", ids, out, make(map[string]Entry))
+		out.Close()
+		done <- err
+	}()
+	// The run is now held on 1003, with 1001 finished.
+	<-doer.reached
+
+	tests := []struct {
+		ready bool
+		path  string
+		want  int
+	}{
+		{false, "/healthz", http.StatusOK},
+		{false, "/readyz", http.StatusServiceUnavailable},
+		{true, "/readyz", http.StatusOK},
+		{true, "/progress", http.StatusOK},
+	}
+	for _, tt := range tests {
+		progress.ready.Store(tt.ready)
+		resp, err := http.Get(server.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s with ready %v: got status %d, want %d", tt.path, tt.ready, resp.StatusCode, tt.want)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report ProgressReport
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Region != "region" || report.Processed != 1 || report.Total != 3 {
+		t.Errorf("progress is %s at %d of %d, want region at 1 of 3", report.Region, report.Processed, report.Total)
+	}
+	if report.RegionStats.Written != 1 || report.ETASeconds == nil {
+		t.Errorf("progress has %d written and ETA %v, want 1 written and an ETA", report.RegionStats.Written, report.ETASeconds)
+	}
+
+	close(doer.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+)
+
+// downloads paces thumbnail downloads for -checksum-images. They go to
+// Flickr's static servers rather than the API, so they don't count towards
+// the hourly cap, but are spaced out all the same.
+var downloads = &requestLimiter{}
+
+// imageHash downloads the thumbnail of a photo and hashes its bytes, returning
+// "" if the photo has no sizes. Identical hashes mean an identical image,
+// which catches reposts of the same file under a new id.
+func imageHash(ctx context.Context, sizes []PictureSize) (string, error) {
+	thumb, ok := thumbnailSize(sizes)
+	if !ok {
+		return "", nil
+	}
+
+	if err := downloads.Wait(ctx, 0); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, thumb.Source, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading thumbnail: HTTP status %d", resp.StatusCode)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// thumbnailSize returns the Thumbnail size, or else the smallest one.
+func thumbnailSize(sizes []PictureSize) (PictureSize, bool) {
+	if len(sizes) == 0 {
+		return PictureSize{}, false
+	}
+	smallest := sizes[0]
+	for _, size := range sizes {
+		if size.Label == "Thumbnail" {
+			return size, true
+		}
+		if size.Width < smallest.Width {
+			smallest = size
+		}
+	}
+	return smallest, true
+}
+
+// writeDuplicates saves out/<region>.duplicates.json, mapping the lowest id
+// with each image hash to the other ids with the same hash.
+func writeDuplicates(region string, entries map[string]Entry) {
+	byHash := make(map[string][]string)
+	for id, entry := range entries {
+		if entry.Region == region && entry.ImageHash != "" {
+			byHash[entry.ImageHash] = append(byHash[entry.ImageHash], id)
+		}
+	}
+
+	duplicates := make(map[string][]string)
+	for _, ids := range byHash {
+		if len(ids) < 2 {
+			continue
+		}
+		slices.Sort(ids)
+		duplicates[ids[0]] = ids[1:]
+	}
+	if len(duplicates) > 0 {
+		log.Printf("Found %d images in %s with duplicates under other ids", len(duplicates), region)
+	}
+
+	data, err := json.MarshalIndent(duplicates, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(outPath(region+".duplicates.json"), data, fileMode); err != nil {
+		log.Fatal(err)
+	}
+}
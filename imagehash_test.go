@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// thumbnailDoer answers downloads from the static servers with the same bytes
+// for every photo in same, and bytes of their own for the rest, passing API
+// calls on to next.
+type thumbnailDoer struct {
+	next Doer
+	same []string
+}
+
+func (d thumbnailDoer) Do(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Host, ".staticflickr.com") {
+		return d.next.Do(req)
+	}
+	body := req.URL.Path
+	for _, id := range d.same {
+		if strings.Contains(req.URL.Path, "/"+id+"_") {
+			body = "the same image"
+		}
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestChecksumImagesFindsDuplicates(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	httpClient = thumbnailDoer{next: httpClient, same: []string{"1001", "1003"}}
+	old := *checksumImages
+	defer func() { *checksumImages = old }()
+	*checksumImages = true
+
+	out, existing := openRegionOutput("region")
+	if _, err := processRegion(context.Background(), "region", []string{"1003", "1001", "1006"}, out, existing); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+
+	entries := parseExisting(outputFile("region"))
+	if hash := entries["1001"].ImageHash; hash == "" || hash != entries["1003"].ImageHash || hash == entries["1006"].ImageHash {
+		t.Errorf("image hashes are %q, %q and %q, want 1001 and 1003 alone to match",
+			hash, entries["1003"].ImageHash, entries["1006"].ImageHash)
+	}
+	data, err := os.ReadFile(outPath("region.duplicates.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var duplicates map[string][]string
+	if err := json.Unmarshal(data, &duplicates); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]string{"1001": {"1003"}}; !reflect.DeepEqual(duplicates, want) {
+		t.Errorf("duplicates are %v, want %v", duplicates, want)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+
+	_ "modernc.org/sqlite"
+)
+
+// seenIndex is the -index db record of which ids a region's output has, kept
+// in out/<region>.index.db so that huge regions needn't be read into memory.
+// It remembers how far it has read each output file, so opening it only reads
+// what has been appended since. A file that has been rewritten since, such as
+// by compact, removeBlocked or scan-images -prune, is told apart from one only
+// appended to by a hash of the bytes before where it was read to, and has its
+// ids dropped and read again from the start. A nil index has no ids.
+type seenIndex struct {
+	db *sql.DB
+	// added is the ids given entries this run. They are only put in the db
+	// once the index next reads them from the output, so that an entry that
+	// was queued but never written isn't taken as being in the output.
+	added map[string]bool
+}
+
+// indexCheckBytes is how much of a file before where it was read to is hashed
+// to tell whether it has been rewritten.
+const indexCheckBytes = 64 << 10
+
+// indexed reports whether -index db replaces reading existing entries.
+func indexed() bool {
+	return *indexMode == "db"
+}
+
+// openSeenIndex opens a region's index and brings it up to date with the
+// region's output files.
+func openSeenIndex(region string) *seenIndex {
+	db, err := sql.Open("sqlite", outPath(region+".index.db"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Serializing use through one connection leaves nothing to coordinate.
+	db.SetMaxOpenConns(1)
+	// The ids and files tables are from before ids were kept per file, and
+	// are simply rebuilt.
+	_, err = db.Exec(`
+		DROP TABLE IF EXISTS ids;
+		DROP TABLE IF EXISTS files;
+		CREATE TABLE IF NOT EXISTS indexed_ids (id TEXT NOT NULL, file TEXT NOT NULL, PRIMARY KEY (id, file)) WITHOUT ROWID;
+		CREATE TABLE IF NOT EXISTS indexed_files (name TEXT PRIMARY KEY, read_to INTEGER NOT NULL, check_hash TEXT NOT NULL);
+	`)
+	if err != nil {
+		log.Fatalf("Opening index of %s: %s", region, err)
+	}
+
+	index := &seenIndex{db: db, added: make(map[string]bool)}
+	for _, fname := range regionFiles(region) {
+		index.sync(fname)
+	}
+	return index
+}
+
+// sync adds the ids in fname past where the index last read it to, or all of
+// them if the file has been rewritten since.
+func (idx *seenIndex) sync(fname string) {
+	f, err := os.Open(fname)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var offset int64
+	var checkHash string
+	err = idx.db.QueryRow(`SELECT read_to, check_hash FROM indexed_files WHERE name = ?`, fname).Scan(&offset, &checkHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Fatal(err)
+	}
+	rewritten := offset > info.Size() || offset > 0 && indexCheckHash(f, offset) != checkHash
+	if rewritten {
+		log.Printf("%s has been rewritten since it was last indexed, so indexing it from the start", fname)
+		offset = 0
+	} else if offset == info.Size() {
+		return
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Fatal(err)
+	}
+
+	tx, err := idx.db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+	if rewritten {
+		if _, err := tx.Exec(`DELETE FROM indexed_ids WHERE file = ?`, fname); err != nil {
+			log.Fatal(err)
+		}
+	}
+	insert, err := tx.Prepare(`INSERT OR IGNORE INTO indexed_ids (id, file) VALUES (?, ?)`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dec := json.NewDecoder(f)
+	var added int
+	for {
+		var line json.RawMessage
+		err := dec.Decode(&line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Indexing %s: %s", fname, err)
+		}
+		if isMetaLine(line) {
+			continue
+		}
+		var entry struct {
+			Id string `json:"id"`
+		}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			log.Fatalf("Indexing %s: %s", fname, err)
+		}
+		if _, err := insert.Exec(entry.Id, fname); err != nil {
+			log.Fatal(err)
+		}
+		added++
+	}
+	readTo := offset + dec.InputOffset()
+	_, err = tx.Exec(`INSERT INTO indexed_files (name, read_to, check_hash) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET read_to = excluded.read_to, check_hash = excluded.check_hash`,
+		fname, readTo, indexCheckHash(f, readTo))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+	if added > 0 {
+		log.Printf("Indexed %d entries in %s", added, fname)
+	}
+}
+
+// indexCheckHash hashes the indexCheckBytes of f before end, which change if
+// anything before end is removed or changed.
+func indexCheckHash(f *os.File, end int64) string {
+	start := max(0, end-indexCheckBytes)
+	buf := make([]byte, end-start)
+	if _, err := f.ReadAt(buf, start); err != nil {
+		log.Fatal(err)
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// has reports whether the region's output has an entry for id.
+func (idx *seenIndex) has(id string) bool {
+	if idx == nil {
+		return false
+	}
+	if idx.added[id] {
+		return true
+	}
+	var one int
+	err := idx.db.QueryRow(`SELECT 1 FROM indexed_ids WHERE id = ? LIMIT 1`, id).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return true
+}
+
+// add records that an entry for id has been queued to be written.
+func (idx *seenIndex) add(id string) {
+	if idx == nil {
+		return
+	}
+	idx.added[id] = true
+}
+
+func (idx *seenIndex) Close() {
+	if idx == nil {
+		return
+	}
+	if err := idx.db.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSeenIndexAfterCompact(t *testing.T) {
+	useDirs(t)
+	writeFile(t, filepath.Join(*ingestDir, "region.ndjson"), `{"id":"1"}`, `{"id":"3"}`, `{"id":"4"}`)
+	writeEntries(t, outputFile("region"), Entry{Id: "1"}, Entry{Id: "2"}, Entry{Id: "3"})
+	index := openSeenIndex("region")
+	index.Close()
+
+	runCompact([]string{"region"})
+	// Appending past where the index read to before the compact, so that
+	// only noticing files that shrank wouldn't catch the rewrite.
+	f, err := os.OpenFile(outputFile("region"), os.O_APPEND|os.O_WRONLY, fileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"id":"4","title":"` + strings.Repeat("x", 1000) + `"}` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index = openSeenIndex("region")
+	defer index.Close()
+	for id, want := range map[string]bool{"1": true, "2": false, "3": true, "4": true} {
+		if got := index.has(id); got != want {
+			t.Errorf("has(%s) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestSeenIndexReadsAppended(t *testing.T) {
+	useDirs(t)
+	writeEntries(t, outputFile("region"), Entry{Id: "1"})
+	index := openSeenIndex("region")
+	index.Close()
+
+	f, err := os.OpenFile(outputFile("region"), os.O_APPEND|os.O_WRONLY, fileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"id":"2"}` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index = openSeenIndex("region")
+	defer index.Close()
+	for _, id := range []string{"1", "2"} {
+		if !index.has(id) {
+			t.Errorf("index is missing %s", id)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKey is one Flickr API key and its recent usage.
+type apiKey struct {
+	key      string
+	hash     string
+	lastUsed time.Time
+	recent   []time.Time // Requests in the last hour, oldest first
+	calls    int64
+}
+
+func newAPIKey(key string) *apiKey {
+	sum := sha256.Sum256([]byte(key))
+	return &apiKey{key: key, hash: hex.EncodeToString(sum[:])[:8]}
+}
+
+// keyPool rotates requests across API keys, picking the least recently used
+// key that is under its own hourly cap. It applies on top of the global
+// pacing in requestLimiter, which still spaces every request.
+type keyPool struct {
+	mu   sync.Mutex
+	keys []*apiKey
+}
+
+var keys = &keyPool{}
+
+// Acquire blocks until a key is under hourlyCap, reserving a request on it.
+func (p *keyPool) Acquire(ctx context.Context, hourlyCap int) (*apiKey, error) {
+	if k, ok := ctx.Value(pinnedKey{}).(*apiKey); ok {
+		p.mu.Lock()
+		k.use(time.Now())
+		p.mu.Unlock()
+		return k, nil
+	}
+
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		var best *apiKey
+		var rollover time.Time
+		for _, k := range p.keys {
+			cutoff := now.Add(-time.Hour)
+			for len(k.recent) > 0 && !k.recent[0].After(cutoff) {
+				k.recent = k.recent[1:]
+			}
+			if hourlyCap > 0 && len(k.recent) >= hourlyCap {
+				at := k.recent[len(k.recent)-hourlyCap].Add(time.Hour)
+				if rollover.IsZero() || at.Before(rollover) {
+					rollover = at
+				}
+				continue
+			}
+			if best == nil || k.lastUsed.Before(best.lastUsed) {
+				best = k
+			}
+		}
+		if best != nil {
+			best.use(now)
+			p.mu.Unlock()
+			return best, nil
+		}
+		p.mu.Unlock()
+
+		log.Printf("Every API key reached the hourly cap of %d calls, pausing until %s", hourlyCap, rollover.Format(time.TimeOnly))
+		select {
+		case <-time.After(time.Until(rollover)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (k *apiKey) use(at time.Time) {
+	k.lastUsed = at
+	k.recent = append(k.recent, at)
+	k.calls++
+}
+
+// Len returns the number of keys in the pool.
+func (p *keyPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.keys)
+}
+
+// Usage returns the calls made with each key, by key hash.
+func (p *keyPool) Usage() map[string]int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	usage := make(map[string]int64)
+	for _, k := range p.keys {
+		usage[k.hash] = k.calls
+	}
+	return usage
+}
+
+type pinnedKey struct{}
+
+// withKey returns a context in which callFlickr always uses k.
+func withKey(ctx context.Context, k *apiKey) context.Context {
+	return context.WithValue(ctx, pinnedKey{}, k)
+}
+
+// parseAPIKeys reads the keys for -api-keys, which is either a comma-separated
+// list or, prefixed with @, a file with one key per line.
+func parseAPIKeys(s string) []string {
+	if name, ok := strings.CutPrefix(s, "@"); ok {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s = strings.ReplaceAll(string(data), "\n", ",")
+	}
+	var keys []string
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyPoolRotates(t *testing.T) {
+	pool := &keyPool{keys: []*apiKey{newAPIKey("a"), newAPIKey("b"), newAPIKey("c")}}
+	seen := make(map[string]bool)
+	for range 3 {
+		k, err := pool.Acquire(context.Background(), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[k.key] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("first three requests used keys %v, want each key once", seen)
+	}
+}
+
+func TestKeyPoolEnforcesCapPerKey(t *testing.T) {
+	const hourlyCap = 5
+	pool := &keyPool{keys: []*apiKey{newAPIKey("a"), newAPIKey("b"), newAPIKey("c")}}
+
+	var wg sync.WaitGroup
+	for range 3 * hourlyCap {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Acquire(context.Background(), hourlyCap); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	for hash, calls := range pool.Usage() {
+		if calls != hourlyCap {
+			t.Errorf("key %s made %d calls, want %d", hash, calls, hourlyCap)
+		}
+	}
+
+	// Every key is at the cap, so the next request waits for the hour to
+	// roll over.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if k, err := pool.Acquire(ctx, hourlyCap); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got key %v and error %v, want to wait past the deadline", k, err)
+	}
+}
+
+func TestKeyPoolForgetsRequestsOverAnHourOld(t *testing.T) {
+	k := newAPIKey("a")
+	pool := &keyPool{keys: []*apiKey{k}}
+	k.recent = []time.Time{time.Now().Add(-2 * time.Hour), time.Now().Add(-90 * time.Minute)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := pool.Acquire(ctx, 2); err != nil {
+		t.Fatalf("key whose requests are over an hour old is still capped: %s", err)
+	}
+	if len(k.recent) != 1 {
+		t.Errorf("key has %d recent requests, want 1", len(k.recent))
+	}
+}
+
+func TestKeyPoolPinnedKey(t *testing.T) {
+	a, b := newAPIKey("a"), newAPIKey("b")
+	pool := &keyPool{keys: []*apiKey{a, b}}
+	ctx := withKey(context.Background(), b)
+	for range 3 {
+		k, err := pool.Acquire(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k != b {
+			t.Fatalf("got key %s, want the pinned key %s", k.key, b.key)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+type license struct {
+	name  string // As Flickr names it
+	short string // The usual abbreviation, for attributions
+	url   string
+}
+
+// licenses are Flickr's license ids.
+var licenses = map[string]license{
+	"0":  {"All Rights Reserved", "All Rights Reserved", ""},
+	"1":  {"Attribution-NonCommercial-ShareAlike License", "CC BY-NC-SA 2.0", "https://creativecommons.org/licenses/by-nc-sa/2.0/"},
+	"2":  {"Attribution-NonCommercial License", "CC BY-NC 2.0", "https://creativecommons.org/licenses/by-nc/2.0/"},
+	"3":  {"Attribution-NonCommercial-NoDerivs License", "CC BY-NC-ND 2.0", "https://creativecommons.org/licenses/by-nc-nd/2.0/"},
+	"4":  {"Attribution License", "CC BY 2.0", "https://creativecommons.org/licenses/by/2.0/"},
+	"5":  {"Attribution-ShareAlike License", "CC BY-SA 2.0", "https://creativecommons.org/licenses/by-sa/2.0/"},
+	"6":  {"Attribution-NoDerivs License", "CC BY-ND 2.0", "https://creativecommons.org/licenses/by-nd/2.0/"},
+	"7":  {"No known copyright restrictions", "No known copyright restrictions", "https://www.flickr.com/commons/usage/"},
+	"8":  {"United States Government Work", "United States Government Work", "http://www.usa.gov/copyright.shtml"},
+	"9":  {"Public Domain Dedication (CC0)", "CC0 1.0", "https://creativecommons.org/publicdomain/zero/1.0/"},
+	"10": {"Public Domain Mark", "Public Domain Mark 1.0", "https://creativecommons.org/publicdomain/mark/1.0/"},
+}
+
+// describeLicense fills in an entry's license name and URL, and its
+// attribution.
+func describeLicense(entry *Entry) {
+	if l, ok := licenses[entry.License]; ok {
+		entry.LicenseName, entry.LicenseURL = l.name, l.url
+	}
+	entry.Attribution = attribution(*entry)
+}
+
+// attribution fills in -attribution-template for an entry, following the
+// Creative Commons "Title by Author is licensed under License" pattern. The
+// author is the owner's real name if known, else their username.
+func attribution(entry Entry) string {
+	title := entry.Title
+	if title == "" {
+		title = "Untitled"
+	}
+	author := entry.OwnerRealName
+	if author == "" {
+		author = entry.OwnerUsername
+	}
+	if author == "" {
+		author = "an unknown author"
+	}
+	licenseName := "an unknown license"
+	if l, ok := licenses[entry.License]; ok {
+		licenseName = l.short
+	}
+
+	s := strings.NewReplacer(
+		"{title}", title,
+		"{author}", author,
+		"{license}", licenseName,
+		"{url}", entry.Webpage,
+		"{changes}", *attrChanges,
+	).Replace(*attrTemplate)
+	return strings.TrimSpace(s)
+}
+
+// loadLicenses replaces the names and URLs in licenses with Flickr's current
+// list, which also picks up any license ids added since the table was
+// written. If the list can't be fetched, the table is used as is.
+func loadLicenses(ctx context.Context) {
+	var resp struct {
+		Licenses struct {
+			License []struct {
+				Id   flexString `json:"id"`
+				Name string     `json:"name"`
+				URL  string     `json:"url"`
+			} `json:"license"`
+		} `json:"licenses"`
+	}
+	if err := callFlickr(ctx, "flickr.photos.licenses.getInfo", &resp, map[string]string{}); err != nil {
+		log.Printf("Warning: using the built in license list, since fetching Flickr's failed: %s", err)
+		return
+	}
+	for _, live := range resp.Licenses.License {
+		l, known := licenses[string(live.Id)]
+		if !known {
+			log.Printf("Flickr has a license not in the built in list: %s (%s)", live.Id, live.Name)
+			l.short = live.Name
+		}
+		l.name = live.Name
+		l.url = live.URL
+		licenses[string(live.Id)] = l
+	}
+}
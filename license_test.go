@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"testing"
+)
+
+func TestAttribution(t *testing.T) {
+	full := Entry{
+		Title:         "Ridge above the tarn",
+		OwnerRealName: "Alex Fell",
+		OwnerUsername: "hillwalker",
+		License:       "4",
+		Webpage:       "https://www.flickr.com/photos/hillwalker/1001/",
+	}
+	tests := []struct {
+		name     string
+		entry    func(e Entry) Entry
+		template string
+		changes  string
+		want     string
+	}{
+		{
+			"default template",
+			func(e Entry) Entry { return e },
+			"", "",
+			`"Ridge above the tarn" by Alex Fell (https://www.flickr.com/photos/hillwalker/1001/) is licensed under CC BY 2.0.`,
+		},
+		{
+			"changes made",
+			func(e Entry) Entry { return e },
+			"", "Cropped.",
+			`"Ridge above the tarn" by Alex Fell (https://www.flickr.com/photos/hillwalker/1001/) is licensed under CC BY 2.0. Cropped.`,
+		},
+		{
+			"no real name",
+			func(e Entry) Entry { e.OwnerRealName = ""; return e },
+			"{author}", "",
+			"hillwalker",
+		},
+		{
+			"no author",
+			func(e Entry) Entry { e.OwnerRealName, e.OwnerUsername = "", ""; return e },
+			"{author}", "",
+			"an unknown author",
+		},
+		{
+			"no title",
+			func(e Entry) Entry { e.Title = ""; return e },
+			"{title}", "",
+			"Untitled",
+		},
+		{
+			"unknown license",
+			func(e Entry) Entry { e.License = "99"; return e },
+			"{license}", "",
+			"an unknown license",
+		},
+		{
+			"custom template",
+			func(e Entry) Entry { return e },
+			"{license}: {title} / {author} <{url}>", "",
+			"CC BY 2.0: Ridge above the tarn / Alex Fell <https://www.flickr.com/photos/hillwalker/1001/>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldTemplate, oldChanges := *attrTemplate, *attrChanges
+			defer func() { *attrTemplate, *attrChanges = oldTemplate, oldChanges }()
+			if tt.template != "" {
+				*attrTemplate = tt.template
+			}
+			*attrChanges = tt.changes
+
+			if got := attribution(tt.entry(full)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadLicenses(t *testing.T) {
+	const liveList = `{"licenses":{"license":[
+		{"id":4,"name":"CC BY 2.0 (renamed)","url":"https://creativecommons.org/licenses/by/2.0/deed"},
+		{"id":"11","name":"CC BY 4.0","url":"https://creativecommons.org/licenses/by/4.0/"}
+	]},"stat":"ok"}`
+	tests := []struct {
+		name              string
+		bodies            map[string]string
+		license           string
+		wantName, wantURL string
+		wantAttributedAs  string
+	}{
+		{
+			"live name",
+			map[string]string{"flickr.photos.licenses.getInfo": liveList},
+			"4", "CC BY 2.0 (renamed)", "https://creativecommons.org/licenses/by/2.0/deed", "CC BY 2.0",
+		},
+		{
+			"license added since the table",
+			map[string]string{"flickr.photos.licenses.getInfo": liveList},
+			"11", "CC BY 4.0", "https://creativecommons.org/licenses/by/4.0/", "CC BY 4.0",
+		},
+		{
+			"list unavailable",
+			nil,
+			"4", "Attribution License", "https://creativecommons.org/licenses/by/2.0/", "CC BY 2.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFixtures(t)
+			old := maps.Clone(licenses)
+			defer func() { licenses = old }()
+			// There's no recording of the license list, so without an
+			// override the call fails.
+			httpClient = overrideDoer{next: httpClient, bodies: tt.bodies}
+			oldTemplate := *attrTemplate
+			defer func() { *attrTemplate = oldTemplate }()
+			*attrTemplate = "{license}"
+
+			loadLicenses(context.Background())
+			entry := Entry{License: tt.license}
+			describeLicense(&entry)
+			if entry.LicenseName != tt.wantName || entry.LicenseURL != tt.wantURL {
+				t.Errorf("got %q %q, want %q %q", entry.LicenseName, entry.LicenseURL, tt.wantName, tt.wantURL)
+			}
+			if entry.Attribution != tt.wantAttributedAs {
+				t.Errorf("attributed as %q, want %q", entry.Attribution, tt.wantAttributedAs)
+			}
+		})
+	}
+}
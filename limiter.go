@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// requestInterval is the minimum time between any two Flickr API requests.
+// Only tests and selftest change it, since they make no real requests.
+var requestInterval = 1 * time.Second
+
+// requestLimiter paces every Flickr API request. Besides spacing requests by
+// requestInterval, it tracks requests in a rolling one-hour window and waits
+// for the window to roll over rather than exceed the hourly cap.
+//
+// With -adaptive-rate, the spacing instead follows a rate that adapts to
+// throttling: it grows by adaptiveStep after every adaptiveWindow successful
+// requests in a row and halves whenever Flickr throttles us, staying between
+// -min-rate and -max-rate.
+type requestLimiter struct {
+	mu     sync.Mutex
+	next   time.Time
+	recent []time.Time // Reserved request times in the last hour, oldest first
+	peak   int
+
+	rate      float64 // Requests per second, or 0 to space by requestInterval
+	minRate   float64
+	maxRate   float64
+	successes int // Since the rate last changed
+}
+
+const (
+	adaptiveWindow = 50
+	adaptiveStep   = 0.05 // Requests per second
+)
+
+var requests = &requestLimiter{}
+
+// Wait blocks until the next request slot, reserving it.
+func (l *requestLimiter) Wait(ctx context.Context, hourlyCap int) error {
+	l.mu.Lock()
+	at := time.Now()
+	if at.Before(l.next) {
+		at = l.next
+	}
+	if hourlyCap > 0 && len(l.recent) >= hourlyCap {
+		if rollover := l.recent[len(l.recent)-hourlyCap].Add(time.Hour); rollover.After(at) {
+			log.Printf("Reached hourly cap of %d calls, pausing until %s", hourlyCap, rollover.Format(time.TimeOnly))
+			at = rollover
+		}
+	}
+	cutoff := at.Add(-time.Hour)
+	for len(l.recent) > 0 && !l.recent[0].After(cutoff) {
+		l.recent = l.recent[1:]
+	}
+	l.recent = append(l.recent, at)
+	l.peak = max(l.peak, len(l.recent))
+	l.next = at.Add(l.interval())
+	l.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(at)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// interval returns the spacing between requests. l.mu must be held.
+func (l *requestLimiter) interval() time.Duration {
+	if l.rate == 0 {
+		return requestInterval
+	}
+	return time.Duration(float64(time.Second) / l.rate)
+}
+
+// adapt makes the limiter adapt its rate between minRate and maxRate requests
+// per second, starting cautiously at minRate.
+func (l *requestLimiter) adapt(minRate, maxRate float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = minRate
+	l.minRate = minRate
+	l.maxRate = maxRate
+}
+
+// Observe adapts the rate to the outcome of a request. Failures other than
+// throttling say nothing about the rate, so are ignored.
+func (l *requestLimiter) Observe(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return
+	}
+	old := l.rate
+	switch {
+	case isThrottled(err):
+		l.rate = max(l.minRate, l.rate/2)
+		l.successes = 0
+	case err == nil:
+		l.successes++
+		if l.successes < adaptiveWindow {
+			return
+		}
+		l.rate = min(l.maxRate, l.rate+adaptiveStep)
+		l.successes = 0
+	}
+	if l.rate != old {
+		log.Printf("Adapting request rate from %.2f to %.2f per second", old, l.rate)
+	}
+}
+
+// isThrottled reports whether Flickr refused a request because we are making
+// too many: HTTP 429, or error 105, service currently unavailable.
+func isThrottled(err error) bool {
+	var statusErr *HTTPStatusError
+	var flickrErr *FlickrError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests ||
+		errors.As(err, &flickrErr) && flickrErr.Code == 105
+}
+
+// Peak returns the most requests made in any rolling hour so far.
+func (l *requestLimiter) Peak() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.peak
+}
+
+// ownerLimiter spaces out hydrating photos that belong to the same owner. It
+// applies on top of the global pacing in callFlickr rather than replacing it,
+// so a request waits for whichever of the two is later.
+type ownerLimiter struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+var owners = &ownerLimiter{next: make(map[string]time.Time)}
+
+// Wait blocks until the owner's next slot, reserving the one after it.
+func (l *ownerLimiter) Wait(ctx context.Context, nsid string, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next[nsid]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[nsid] = at.Add(interval)
+	l.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(at)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRate(t *testing.T) {
+	throttled := &HTTPStatusError{Method: "flickr.photos.getInfo", StatusCode: http.StatusTooManyRequests}
+	tests := []struct {
+		name     string
+		outcomes []error
+		want     float64
+	}{
+		{"starts at the minimum", nil, 1},
+		{"unchanged short of a window", successes(adaptiveWindow - 1), 1},
+		{"grows after a window", successes(adaptiveWindow), 1 + adaptiveStep},
+		{"grows after each window", successes(3 * adaptiveWindow), 1 + 3*adaptiveStep},
+		{"capped at the maximum", successes(1000 * adaptiveWindow), 2},
+		{"halves when throttled", append(successes(1000*adaptiveWindow), throttled), 1},
+		{"not below the minimum", []error{throttled, throttled}, 1},
+		{"error 105 is throttling", append(successes(1000*adaptiveWindow), &FlickrError{Code: 105}), 1},
+		{"other errors are ignored", append(successes(1000*adaptiveWindow), errors.New("connection reset")), 2},
+		{
+			"throttling restarts the window",
+			append(append(successes(adaptiveWindow-1), &FlickrError{Code: 105}), successes(adaptiveWindow-1)...),
+			1,
+		},
+		{
+			"other errors don't restart the window",
+			append(append(successes(adaptiveWindow-1), fmt.Errorf("timeout")), successes(1)...),
+			1 + adaptiveStep,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &requestLimiter{}
+			l.adapt(1, 2)
+			for _, err := range tt.outcomes {
+				l.Observe(err)
+			}
+			if math.Abs(l.rate-tt.want) > 1e-9 {
+				t.Errorf("rate is %v, want %v", l.rate, tt.want)
+			}
+			if want := time.Duration(float64(time.Second) / tt.want); l.interval() != want {
+				t.Errorf("interval is %s, want %s", l.interval(), want)
+			}
+		})
+	}
+}
+
+func TestObserveWithoutAdaptiveRate(t *testing.T) {
+	l := &requestLimiter{}
+	l.Observe(&FlickrError{Code: 105})
+	for range successes(adaptiveWindow) {
+		l.Observe(nil)
+	}
+	if l.rate != 0 || l.interval() != requestInterval {
+		t.Errorf("rate is %v with interval %s, want requests spaced by requestInterval", l.rate, l.interval())
+	}
+}
+
+func successes(n int) []error {
+	return make([]error, n)
+}
@@ -1,105 +1,1232 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
-var flickrAPIKey string
+var (
+	fileMode os.FileMode = 0640
+	dirMode  os.FileMode = 0750
+)
+
+var (
+	strict          = flag.Bool("strict", false, "Treat problems with ingest files as fatal")
+	geoFallback     = flag.Bool("geo-fallback", false, "Call flickr.photos.geo.getLocation when getInfo has no coordinates")
+	allowWoeid      = flag.String("allow-woeid", "", "Comma-separated WOEIDs; skip photos not located in one of these places")
+	denyWoeid       = flag.String("deny-woeid", "", "Comma-separated WOEIDs; skip photos located in any of these places")
+	requireLocation = flag.Bool("require-location", false, "Skip photos without coordinates")
+	keepUnlocated   = flag.Bool("keep-unlocated", false, "Keep photos without coordinates, marking every entry with hasLocation")
+	publicOnly      = flag.Bool("public-location-only", false, "Skip photos whose location is not publicly visible")
+	appendTo        = flag.String("append-to", "", "Append entries for all regions to this file instead of per-region files")
+	enrich          = flag.String("enrich", "", "Comma-separated extra calls to make per photo, from: "+strings.Join(enrichmentNames(), ","))
+	favSample       = flag.Int("favorites-sample", 50, "Maximum number of favoriters to record per photo")
+	ownerCacheSize  = flag.Int("owner-cache-size", 10000, "Most owners to remember the flickr.people.getInfo of for -enrich owner, forgetting the least recently used (0 for no limit)")
+	ownerPacing     = flag.Duration("owner-interval", 0, "Minimum time between photos from the same owner, on top of the global rate limit")
+	withNotes       = flag.Bool("with-notes", false, "Store the text notes placed on each photo, which getInfo already returns")
+	canonLabels     = flag.Bool("canonical-size-labels", false, "Add a canonicalLabel alongside Flickr's label for each size")
+	stripMarkup     = flag.Bool("strip-html", false, "Store title and description as plain text, keeping the raw description as descriptionHTML")
+	maxTextLength   = flag.Int("max-description-length", 0, "Truncate titles and descriptions to this many characters, ending with an ellipsis (0 for no limit)")
+	keepFullText    = flag.Bool("keep-full-text", false, "With -max-description-length, also store truncated text in full as titleFull and descriptionFull")
+	minWidth        = flag.Int("min-width", 0, "Skip photos whose largest size is narrower than this many pixels")
+	contentTypes    = flag.String("content-types", "1", "Comma-separated Flickr content types to keep: 1 photo, 2 screenshot, 3 other (empty for all)")
+	requireUsage    = flag.String("require-usage", "", "Comma-separated permissions a photo's owner must grant, from download, blog, print and share")
+	minViews        = flag.Int("min-views", 0, "Skip photos with fewer views than this")
+	minFavorites    = flag.Int("min-favorites", 0, "Skip photos with fewer favorites than this (implies -enrich favorites)")
+	coordPrecision  = flag.Int("coord-precision", -1, "Round coordinates to this many decimal places, 5 being about 1m (-1 to keep Flickr's precision)")
+	partitionBy     = flag.String("partition-by", "", "Split each region's output into out/<region>/<partition>.ndjson. Only year, by year taken, is supported")
+	metricsAddr     = flag.String("metrics-addr", "", "Serve /healthz, /readyz (ready once preflight passes) and /progress on this address, such as :9090")
+	prefetchAhead   = flag.Int("prefetch", 0, "Hydrate up to this many photos ahead of the one being written, so that request latency overlaps the rate limiter's wait")
+	cdn             = flag.String("cdn", cdnFarm, "Host for the static URLs we build, such as buddy icons: farm (farmN.staticflickr.com) or live (live.staticflickr.com)")
+	assertSchema    = flag.String("assert-schema", "", "Check each entry against this JSON Schema before writing it, or builtin for the embedded one. Violations are fatal under -strict")
+	indexMode       = flag.String("index", "memory", "How to know which ids are already written: memory, by reading the output, or db, with an on-disk index that needs far less memory for huge regions")
+	geoMismatchKm   = flag.Float64("geo-mismatch-km", 1, "Skip photos further than this from the coordinates their ingest line expects, recording them in out/<region>.geo-mismatch.ndjson")
+	adaptiveRate    = flag.Bool("adaptive-rate", false, "Adapt the request rate to throttling between -min-rate and -max-rate, instead of one request a second")
+	minRate         = flag.Float64("min-rate", 0.25, "Requests per second -adaptive-rate starts at and never goes below")
+	maxRate         = flag.Float64("max-rate", 1, "Requests per second -adaptive-rate never goes above")
+	writeBuffer     = flag.Int("write-buffer", 64<<10, "Bytes of output to buffer before writing them to the file, or 0 to write each entry as it comes")
+	saveRaw         = flag.Bool("save-raw", false, "Keep every successful API response in out/raw, so that replay can rebuild output from them")
+	emptyPolicy     = flag.String("empty-policy", emptyAsString, "How to write empty strings, zero numbers and zero times: empty-string (as they are), null, or omit. The id is always written")
+	dedupeBy        = flag.String("dedupe-by", "id", "What makes two photos duplicates: id, owner-date (same owner, time taken and title) or image-hash (needs -checksum-images)")
+	checksumImages  = flag.Bool("checksum-images", false, "Download each photo's thumbnail to find reposts of the same image, writing out/<region>.duplicates.json")
+	displayStrategy = flag.String("display-strategy", "closest", "How to choose displayUrl: closest to -display-width, or largest-under -display-max-width")
+	displayWidth    = flag.Int("display-width", 0, "Target width for -display-strategy closest (0 to not choose a display size)")
+	displayMaxWidth = flag.Int("display-max-width", 0, "Width ceiling for -display-strategy largest-under")
+	splitBySize     = flag.Bool("split-by-size", false, "Also write small, medium and large tier files listing each photo's URL for that tier")
+	noSizes         = flag.Bool("no-sizes", false, "Skip flickr.photos.getSizes, leaving sizes empty")
+	refreshAge      = flag.Duration("refresh-older-than", 0, "Re-fetch existing entries retrieved longer ago than this (0 to never refresh)")
+	lang            = flag.String("lang", "", "Language to request place names in, such as fr-fr (empty for Flickr's default)")
+	preserveFields  = flag.String("preserve-fields", "local", "Comma-separated entry fields to keep from the existing entry when refreshing it")
+	search          = flag.String("search", "", "Ingest the results of flickr.photos.search with these query parameters (e.g. \"tags=hills&has_geo=1\") into -region")
+	refetchMissing  = flag.String("refetch-if-missing", "", "Re-fetch existing entries whose field at this dotted path, such as exif.iso, is missing or zero")
+	resumeFailed    = flag.Bool("resume-failed", false, "Retry the ids in every region's failures file instead of the ingest directory")
+	sinceManifest   = flag.Bool("since-manifest", false, "Only process ingest ids appended since the last run, using the offsets in out/run-summary.json")
+	stdin           = flag.Bool("stdin", false, "Read ids for -region from standard input instead of the ingest directory")
+	regionName      = flag.String("region", "", "Region to write to when not ingesting from the ingest directory")
+	start           = flag.Int("start", 0, "Only process ingest ids from this 1-based position, after removing duplicates and -reverse")
+	end             = flag.Int("end", 0, "Only process ingest ids up to and including this 1-based position")
+	ingestDir       = flag.String("ingest-dir", "ingest", "Directory of ingest files, one per region")
+	outDir          = flag.String("out-dir", "out", "Directory to write output to")
+	ingestExt       = flag.String("ingest-ext", ".ndjson", "Comma-separated extensions of ingest files, such as .ndjson,.jsonl")
+	outExt          = flag.String("out-ext", ".ndjson", "Extension of the per-region output files")
+	reverse         = flag.Bool("reverse", false, "Process each region's ids last first, before applying -start, -end and -limit")
+	limit           = flag.Int("limit", 0, "Only process the first this many ids of each region, after -start and -end (0 for no limit)")
+	shard           = flag.String("shard", "", "Only process ids in shard i of n, given as \"i/n\" with 0 <= i < n")
+	fileModeFlag    = flag.String("file-mode", "0640", "Permissions for output files, in octal")
+	dirModeFlag     = flag.String("dir-mode", "0750", "Permissions for the output directory, in octal")
+	blocklistFile   = flag.String("blocklist", "", "File of ids that must never be hydrated, and are removed from existing output")
+	blockIds        = flag.String("block-ids", "", "Comma-separated ids to treat as if they were in -blocklist")
+	topup           = flag.Bool("topup", false, "Only fetch enrichments that existing entries are missing or that previously failed")
+	attrTemplate    = flag.String("attribution-template", `"{title}" by {author} ({url}) is licensed under {license}. {changes}`, "Format of each entry's attribution, with {title}, {author}, {license}, {url} and {changes} placeholders")
+	attrChanges     = flag.String("attribution-changes", "", "Text for {changes} in -attribution-template, describing how photos were modified")
+	schemaHeader    = flag.Bool("schema-header", false, "Start new output files with a _meta line giving the schema version")
+	dryRun          = flag.Bool("dry-run", false, "Estimate the API calls and time a run would take without calling Flickr")
+	errorsFile      = flag.String("errors-file", "", "Also append each failure to this file as JSON with its region, method, error code and whether it is worth retrying")
+	webhookURL      = flag.String("webhook-url", "", "POST each entry written to this URL, recording undeliverable ones in out/<region>.webhook-failed.ndjson")
+	failFast        = flag.Bool("fail-fast", false, "Abort the run on the first photo that fails to hydrate")
+	apiKeys         = flag.String("api-keys", "", "Comma-separated Flickr API keys to rotate across, or @file with one per line, instead of FLICKR_API_KEY")
+	retries         = flag.Int("retries", 2, "Times to retry a Flickr call that fails with a connection problem, rate limiting or a server error")
+	retryBudget     = flag.Int("retry-budget", 500, "Most retries to make over the whole run, after which failing calls fail the photo immediately (0 for no limit)")
+	maxResponse     = flag.Int64("max-response-bytes", 10<<20, "Fail Flickr calls whose response body is larger than this")
+	hourlyCap       = flag.Int("hourly-cap", 3500, "Maximum Flickr API calls per API key in any rolling hour (0 for no cap)")
+	deadTTL         = flag.Duration("dead-ttl", 30*24*time.Hour, "How long to remember that a photo was not found before requesting it again")
+	followMoved     = flag.Bool("follow-moved", false, "When a previously hydrated photo is not found, look for it under a new id in its owner's photostream")
+	otlpEndpoint    = flag.String("otlp-endpoint", "", "Export trace spans over OTLP/HTTP to this base URL, such as http://localhost:4318 (empty to disable tracing)")
+	liveLicenses    = flag.Bool("live-licenses", false, "Fetch Flickr's license list at startup rather than relying on the built in one")
+	seed            = flag.Uint64("seed", 0, "Seed for randomized behavior such as retry jitter, for reproducible runs (0 to seed from the time)")
+	noPreflight     = flag.Bool("no-preflight", false, "Skip checking the API key and connectivity before starting")
+	maxRuntime      = flag.Duration("max-runtime", 0, "Stop as if interrupted once the run has taken this long (0 for no limit)")
+	photoTimeout    = flag.Duration("photo-timeout", 0, "Give up on a photo if hydrating it takes longer than this from its first request (0 for no limit)")
+)
+
+func loadAPIKey() {
+	if *apiKeys != "" {
+		for _, key := range parseAPIKeys(*apiKeys) {
+			keys.keys = append(keys.keys, newAPIKey(key))
+		}
+		if len(keys.keys) == 0 {
+			log.Fatal("-api-keys lists no keys")
+		}
+		return
+	}
 
-func init() {
 	err := godotenv.Load(".local.env")
 	if err != nil {
 		log.Fatal("Error loading .env file", err)
 	}
 
-	flickrAPIKey = os.Getenv("FLICKR_API_KEY")
-	if flickrAPIKey == "" {
+	key := os.Getenv("FLICKR_API_KEY")
+	if key == "" {
 		log.Fatal("FLICKR_API_KEY not set")
 	}
-
+	keys.keys = []*apiKey{newAPIKey(key)}
 }
 
 func main() {
-	outDir := "out"
-	if err := os.MkdirAll(outDir, 0750); err != nil {
+	flag.Parse()
+
+	fileMode = parseMode("-file-mode", *fileModeFlag)
+	dirMode = parseMode("-dir-mode", *dirModeFlag)
+	enabledEnrichments = parseEnrichments(*enrich)
+	if *minFavorites > 0 && !slices.Contains(enabledEnrichments, "favorites") {
+		enabledEnrichments = append(enabledEnrichments, "favorites")
+	}
+
+	if *cdn != cdnFarm && *cdn != cdnLive {
+		log.Fatalf("-cdn must be %s or %s", cdnFarm, cdnLive)
+	}
+	if *displayStrategy != displayClosest && *displayStrategy != displayLargestUnder {
+		log.Fatalf("-display-strategy must be %s or %s", displayClosest, displayLargestUnder)
+	}
+	if *otlpEndpoint != "" {
+		tracer = newTracer(strings.TrimSuffix(*otlpEndpoint, "/"))
+	}
+	allowedContentTypes = parseContentTypes(*contentTypes)
+	if *seed != 0 {
+		seedRand(*seed)
+	}
+	checkLocationFlags()
+	preservedFields = parsePreservedFields(*preserveFields)
+	if *refetchMissing != "" {
+		checkFieldPath(*refetchMissing)
+	}
+	requiredUsage = parseRequiredUsage(*requireUsage)
+	if *webhookURL != "" {
+		hook = newWebhook(*webhookURL)
+	}
+	if *errorsFile != "" {
+		errorReports = &lazyEncoder{fname: *errorsFile}
+		defer errorReports.Close()
+	}
+	checkDedupeBy()
+	checkEmptyPolicy()
+	if *adaptiveRate {
+		if *minRate <= 0 || *minRate > *maxRate {
+			log.Fatal("-min-rate must be positive and at most -max-rate")
+		}
+		requests.adapt(*minRate, *maxRate)
+	}
+	if *indexMode != "" && *indexMode != "memory" && !indexed() {
+		log.Fatalf("-index must be memory or db, not %q", *indexMode)
+	}
+	if indexed() {
+		// These need the existing entries themselves, not just their ids.
+		switch {
+		case *refreshAge != 0, *refetchMissing != "":
+			log.Fatal("-index db only skips ids already written, so can't refresh them")
+		case *dedupeBy != "id", *checksumImages:
+			log.Fatal("-index db only supports -dedupe-by id, without -checksum-images")
+		case *topup, *appendTo != "":
+			log.Fatal("-index db doesn't support -topup or -append-to")
+		}
+	}
+	if *assertSchema != "" {
+		entrySchema = loadSchema(*assertSchema)
+	}
+	if *partitionBy != "" && *partitionBy != "year" {
+		log.Fatalf("-partition-by must be year, not %q", *partitionBy)
+	}
+	if partitioned() && *appendTo != "" {
+		log.Fatal("-partition-by does not support -append-to")
+	}
+	allowedWoeids = parseWoeids(*allowWoeid)
+	deniedWoeids = parseWoeids(*denyWoeid)
+
+	checkDirs()
+	if err := os.MkdirAll(*outDir, dirMode); err != nil {
 		log.Fatal(err)
 	}
 
-	ingestFiles, err := os.ReadDir("ingest")
+	switch flag.Arg(0) {
+	case "":
+	case "compact":
+		runCompact(flag.Args()[1:])
+		return
+	case "generate-ingest":
+		runGenerateIngest(flag.Args()[1:])
+		return
+	case "verify":
+		runVerify(flag.Args()[1:])
+		return
+	case "count":
+		runCount(flag.Args()[1:])
+		return
+	case "report-owners":
+		runReportOwners(flag.Args()[1:])
+		return
+	case "scan-images":
+		runScanImages(flag.Args()[1:])
+		return
+	case "replay":
+		runReplay(flag.Args()[1:])
+		return
+	case "selftest":
+		runSelftest(flag.Args()[1:])
+		return
+	default:
+		log.Fatalf("Unknown command %q", flag.Arg(0))
+	}
+
+	if *search != "" {
+		if *stdin || *resumeFailed {
+			log.Fatal("-search cannot be combined with -stdin or -resume-failed")
+		}
+		loadAPIKey()
+		runSearch()
+		return
+	}
+
+	shardIndex, shardCount := parseShard(*shard)
+
+	var ingests map[string][]string
+	if *stdin && *resumeFailed {
+		log.Fatal("-stdin and -resume-failed are mutually exclusive")
+	}
+	if *stdin {
+		if *regionName == "" {
+			log.Fatal("-stdin requires -region")
+		}
+		ids := rejectNonPhotoIds("stdin", readIDLines(os.Stdin))
+		checkIngest("stdin", *regionName, ids)
+		ingests = map[string][]string{*regionName: ids}
+	} else if *resumeFailed {
+		ingests = loadFailures(*outDir)
+	} else {
+		var from map[string]int64
+		if *sinceManifest {
+			from = previousIngestOffsets()
+		}
+		ingests = loadIngestDir(*ingestDir, from)
+	}
+	for region, ids := range ingests {
+		ingests[region] = selectIds(region, ids)
+	}
+	if shardCount > 1 {
+		for region, ids := range ingests {
+			ingests[region] = filterShard(ids, shardIndex, shardCount)
+			delete(ingestEnds, region)
+		}
+	}
+
+	blocked := loadBlocklist()
+	for region, ids := range ingests {
+		var n int
+		ingests[region], n = filterBlocked(ids, blocked)
+		if n > 0 {
+			log.Printf("Blocked %d ids in region %s", n, region)
+		}
+	}
+
+	if *dryRun {
+		estimateCost(ingests)
+		return
+	}
+
+	loadAPIKey()
+
+	ctx, cancel := runContext()
+	defer cancel()
+	summary := newRunSummary()
+	if *metricsAddr != "" {
+		progress.start(ingests)
+		serveHealth(*metricsAddr)
+	}
+
+	if !*noPreflight {
+		preflight(ctx)
+	}
+	progress.ready.Store(true)
+	if *liveLicenses {
+		loadLicenses(ctx)
+	}
+
+	if *topup {
+		if *appendTo != "" {
+			log.Fatal("-topup does not support -append-to")
+		}
+		for region, ids := range ingests {
+			out, existingEntries := openRegionOutput(region)
+			summary.addRegion(region, topupRegion(ctx, region, ids, out, existingEntries))
+			out.Close()
+		}
+		summary.HitMaxRuntime = errors.Is(ctx.Err(), context.DeadlineExceeded)
+		summary.write()
+		tracer.flush()
+		hook.Close()
+		return
+	}
+
+	var runErr error
+	if *appendTo != "" {
+		// All regions share one writer so their writes to the combined file
+		// are serialized.
+		removeBlocked(*appendTo, blocked)
+		outF := openEntries(*appendTo)
+		out := newEntryWriter(outF)
+		existingEntries := parseExisting(*appendTo)
+		for region, ids := range ingests {
+			stats, err := processRegion(ctx, region, ids, out, existingEntries)
+			summary.addRegion(region, stats)
+			if err != nil {
+				runErr = err
+			}
+			if err != nil || ctx.Err() != nil {
+				break
+			}
+			finishIngest(region)
+		}
+		out.Close()
+		outF.Close()
+	} else {
+		for region, ids := range ingests {
+			for _, fname := range regionFiles(region) {
+				removeBlocked(fname, blocked)
+			}
+			out, existingEntries := openRegionOutput(region)
+			stats, err := processRegion(ctx, region, ids, out, existingEntries)
+			out.Close()
+			summary.addRegion(region, stats)
+			if err != nil {
+				runErr = err
+			}
+			if err != nil || ctx.Err() != nil {
+				break
+			}
+			finishIngest(region)
+		}
+	}
+
+	summary.HitMaxRuntime = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	summary.write()
+	tracer.flush()
+	hook.Close()
+
+	if runErr != nil {
+		cancel()
+		log.Printf("Aborting: %s", runErr)
+		os.Exit(1)
+	}
+	if summary.HitMaxRuntime {
+		log.Printf("Reached -max-runtime of %s, remaining ids were recorded as failures", *maxRuntime)
+	} else if ctx.Err() != nil {
+		log.Print("Interrupted, remaining ids were recorded as failures")
+	}
+}
+
+// runContext returns the context of a run. On SIGTERM or SIGINT the current
+// region records its remaining ids as failures and the run exits cleanly, so
+// that it can simply be retried. Running past -max-runtime is handled like a
+// signal.
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	if *maxRuntime <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, *maxRuntime)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// loadIngestDir parses every ingest file in dir, keyed by region. Files
+// without one of the -ingest-ext extensions are ignored, and files for the
+// same region with different extensions are combined. Each file is read from
+// its offset in from, if any.
+func loadIngestDir(dir string, from map[string]int64) map[string][]string {
+	ingestFiles, err := os.ReadDir(dir)
 	if err != nil {
 		log.Fatal(err)
 	}
 	ingests := make(map[string][]string)
 	for _, dirEntry := range ingestFiles {
-		ids := parseIngest("ingest/" + dirEntry.Name())
-		name := strings.TrimSuffix(dirEntry.Name(), ".ndjson")
-		ingests[name] = ids
+		fname := dir + "/" + dirEntry.Name()
+		name, ok := ingestRegion(dirEntry.Name())
+		if !ok {
+			log.Printf("Ignoring %s, which does not have an -ingest-ext extension", fname)
+			continue
+		}
+		ids, end := parseIngestFrom(fname, from[fname])
+		if ingestEnds[name] == nil {
+			ingestEnds[name] = make(map[string]int64)
+		}
+		ingestEnds[name][fname] = end
+		if from[fname] > 0 {
+			// Having nothing new since the last run is expected.
+			log.Printf("Read %d ids appended to %s since the last run", len(ids), fname)
+		} else {
+			checkIngest(fname, name, ids)
+		}
+		ingests[name] = append(ingests[name], ids...)
+	}
+	return ingests
+}
+
+// ingestExts returns the -ingest-ext extensions, allowing spaces after the
+// commas.
+func ingestExts() []string {
+	var exts []string
+	for _, ext := range strings.Split(*ingestExt, ",") {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	if len(exts) == 0 {
+		log.Fatal("-ingest-ext must list at least one extension")
 	}
+	return exts
+}
 
-	for region, ids := range ingests {
-		processRegion(region, ids)
+// ingestRegion returns the region an ingest file is for.
+func ingestRegion(name string) (string, bool) {
+	for _, ext := range ingestExts() {
+		if region, ok := strings.CutSuffix(name, ext); ok {
+			return region, true
+		}
 	}
+	return "", false
 }
 
-func processRegion(region string, ids []string) {
-	log.Printf("Processing region %s", region)
-	outF, err := os.OpenFile("out/"+region+".ndjson", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+// ingestFile returns the path of a region's ingest file, which is the first
+// that exists of those with an -ingest-ext extension, or else the one with
+// the first extension.
+func ingestFile(region string) string {
+	exts := ingestExts()
+	for _, ext := range exts {
+		fname := filepath.Join(*ingestDir, region+ext)
+		if _, err := os.Stat(fname); err == nil {
+			return fname
+		}
+	}
+	return filepath.Join(*ingestDir, region+exts[0])
+}
+
+// regionIngestFiles returns every ingest file there is for a region, one per
+// -ingest-ext extension.
+func regionIngestFiles(region string) []string {
+	var fnames []string
+	for _, ext := range ingestExts() {
+		fname := filepath.Join(*ingestDir, region+ext)
+		_, err := os.Stat(fname)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fnames = append(fnames, fname)
+	}
+	return fnames
+}
+
+// parseRegionIngest returns the ids in all of a region's ingest files,
+// combined as loadIngestDir combines them.
+func parseRegionIngest(region string) []string {
+	var ids []string
+	for _, fname := range regionIngestFiles(region) {
+		ids = append(ids, parseIngest(fname)...)
+	}
+	return ids
+}
+
+// checkLocationFlags refuses -require-location with -keep-unlocated, which
+// ask for opposite things to be done with photos without coordinates.
+func checkLocationFlags() {
+	if *requireLocation && *keepUnlocated {
+		log.Fatal("-require-location and -keep-unlocated are mutually exclusive")
+	}
+}
+
+// outPath returns the path of a file in the output directory.
+func outPath(name string) string {
+	return filepath.Join(*outDir, name)
+}
+
+// checkDirs refuses to run with output that would be read back as ingest,
+// which happens if -out-dir is -ingest-dir or -append-to is an ingest file.
+func checkDirs() {
+	ingestAbs, err := filepath.Abs(*ingestDir)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer outF.Close()
-	outEnc := json.NewEncoder(outF)
+	outAbs, err := filepath.Abs(*outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ingestAbs == outAbs {
+		log.Fatalf("-ingest-dir and -out-dir are both %s, so output would be read as ingest", ingestAbs)
+	}
+	if *appendTo != "" {
+		appendAbs, err := filepath.Abs(*appendTo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, ok := ingestRegion(filepath.Base(appendAbs)); ok && filepath.Dir(appendAbs) == ingestAbs {
+			log.Fatalf("-append-to %s is in -ingest-dir, so output would be read as ingest", *appendTo)
+		}
+	}
+}
+
+// outputFile returns the path of a region's output file.
+func outputFile(region string) string {
+	return outPath(region + *outExt)
+}
+
+// loadFailures reads the ids in every region's failures file in dir, keyed by
+// region.
+func loadFailures(dir string) map[string][]string {
+	fnames, err := filepath.Glob(filepath.Join(dir, "*.failed.ndjson"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	failures := make(map[string][]string)
+	for _, fname := range fnames {
+		region := strings.TrimSuffix(filepath.Base(fname), ".failed.ndjson")
+		failures[region] = parseFailures(fname)
+	}
+	return failures
+}
+
+func parseFailures(fname string) []string {
+	f, err := os.Open(fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	seen := make(map[string]bool)
+	var ids []string
+	for {
+		var failure Failure
+		err := dec.Decode(&failure)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !seen[failure.Id] {
+			seen[failure.Id] = true
+			ids = append(ids, failure.Id)
+		}
+	}
+	return ids
+}
 
-	existingEntries := parseExisting(region)
+func checkIngest(source, region string, ids []string) {
+	if len(ids) == 0 {
+		if *strict {
+			log.Fatalf("No valid ids in %s (region %s)", source, region)
+		}
+		log.Printf("Warning: no valid ids in %s (region %s)", source, region)
+	}
+}
+
+// readIDLines reads one id per line, accepting bare ids as well as NDJSON
+// ingest lines.
+func readIDLines(r io.Reader) []string {
+	var ids []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, `"`) || strings.HasPrefix(line, "{") {
+			id, err := parseIngestLine(json.RawMessage(line))
+			if err != nil {
+				log.Fatalf("Invalid id %s: %s", line, err)
+			}
+			line = id
+		}
+		ids = append(ids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return ids
+}
+
+func parseMode(name, value string) os.FileMode {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		log.Fatalf("Invalid %s %q: expected octal permissions such as 0640", name, value)
+	}
+	return os.FileMode(mode)
+}
+
+// dedupIds removes repeated ids, keeping the first occurrence of each.
+func dedupIds(ids []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// selectIds returns the ids of a region to process: each once, in the order
+// -reverse asks for, cut down by -start, -end and -limit.
+func selectIds(region string, ids []string) []string {
+	ids = dedupIds(ids)
+	if *reverse {
+		slices.Reverse(ids)
+	}
+	// A region only run in part leaves the offsets of its ingest files
+	// where they were.
+	if *start != 0 || *end != 0 {
+		ids = sliceIds(region, ids, *start, *end)
+		delete(ingestEnds, region)
+	}
+	if *limit > 0 && len(ids) > *limit {
+		ids = ids[:*limit]
+		delete(ingestEnds, region)
+	}
+	return ids
+}
+
+// sliceIds returns ids start through end, counting from 1 and inclusive. Zero
+// means the first or last id respectively.
+func sliceIds(region string, ids []string, start, end int) []string {
+	if start == 0 {
+		start = 1
+	}
+	if end == 0 {
+		end = len(ids)
+	}
+	if start < 1 || end > len(ids) || start > end {
+		log.Fatalf("Invalid -start %d and -end %d for region %s with %d ids", start, end, region, len(ids))
+	}
+	return ids[start-1 : end]
+}
+
+// parseShard parses a -shard value, returning shard 0 of 1 if it is empty.
+func parseShard(value string) (uint32, uint32) {
+	if value == "" {
+		return 0, 1
+	}
+	var i, n uint32
+	if _, err := fmt.Sscanf(value, "%d/%d", &i, &n); err != nil || n == 0 || i >= n {
+		log.Fatalf("Invalid -shard %q: expected i/n with 0 <= i < n", value)
+	}
+	return i, n
+}
 
+// filterShard keeps the ids that hash into shard i of n. The hash only depends
+// on the id, so every id lands in exactly one shard on every machine.
+func filterShard(ids []string, i, n uint32) []string {
+	var kept []string
 	for _, id := range ids {
-		if _, ok := existingEntries[id]; ok {
+		h := fnv.New32a()
+		h.Write([]byte(id))
+		if h.Sum32()%n == i {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+func runSearch() {
+	if *regionName == "" {
+		log.Fatal("-search requires -region")
+	}
+	checkSearchFlags()
+	params, err := url.ParseQuery(*search)
+	if err != nil {
+		log.Fatalf("Invalid -search: %s", err)
+	}
+
+	ctx := context.Background()
+	summary := newRunSummary()
+	if !*noPreflight {
+		preflight(ctx)
+	}
+	if *liveLicenses {
+		loadLicenses(ctx)
+	}
+	summary.addRegion(*regionName, searchRegion(ctx, *regionName, params, loadBlocklist()))
+	summary.write()
+	tracer.flush()
+	hook.Close()
+}
+
+// preflight makes a cheap call so that a bad API key or an unreachable Flickr
+// is discovered before any work is done.
+func preflight(ctx context.Context) {
+	for _, k := range keys.keys {
+		var resp struct{}
+		err := callFlickr(withKey(ctx, k), "flickr.test.echo", &resp, map[string]string{})
+		var flickrErr *FlickrError
+		if errors.As(err, &flickrErr) && flickrErr.Code == 100 {
+			log.Fatalf("Preflight failed: API key %s is invalid (%s)", k.hash, flickrErr.Message)
+		} else if err != nil {
+			log.Fatalf("Preflight failed: %s", err)
+		}
+	}
+}
+
+func openOutput(fname string) *os.File {
+	trimPartialLine(fname)
+
+	f, err := os.OpenFile(fname, os.O_CREATE|os.O_APPEND|os.O_WRONLY, fileMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f
+}
+
+// schemaVersion identifies the shape of entries for -schema-header.
+const schemaVersion = 1
+
+type schemaMeta struct {
+	Meta struct {
+		SchemaVersion int       `json:"schemaVersion"`
+		GeneratedAt   time.Time `json:"generatedAt"`
+	} `json:"_meta"`
+}
+
+// openEntries opens an entry output file for appending, starting it with a
+// metadata line if -schema-header is set and the file is new.
+func openEntries(fname string) *os.File {
+	f := openOutput(fname)
+	if *schemaHeader {
+		info, err := f.Stat()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if info.Size() == 0 {
+			writeSchemaHeader(f)
+		}
+	}
+	return f
+}
+
+func writeSchemaHeader(w io.Writer) {
+	var meta schemaMeta
+	meta.Meta.SchemaVersion = schemaVersion
+	meta.Meta.GeneratedAt = time.Now().UTC()
+	if err := json.NewEncoder(w).Encode(meta); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// isMetaLine reports whether a line of output is a -schema-header line rather
+// than an entry.
+func isMetaLine(line []byte) bool {
+	if !bytes.Contains(line, []byte(`"_meta"`)) {
+		return false
+	}
+	var probe struct {
+		Meta json.RawMessage `json:"_meta"`
+	}
+	return json.Unmarshal(line, &probe) == nil && probe.Meta != nil
+}
+
+// trimPartialLine truncates an incomplete trailing line left by an interrupted
+// write, so that the next entry appended doesn't get concatenated onto it.
+func trimPartialLine(fname string) {
+	f, err := os.OpenFile(fname, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
+	size := info.Size()
+
+	buf := make([]byte, 4096)
+	end := size
+	for end > 0 {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			log.Fatal(err)
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+
+	if end == size {
+		return
+	}
+	log.Printf("Trimming %d bytes of partial line from end of %s", size-end, fname)
+	if err := f.Truncate(end); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// lazyEncoder appends JSON lines to a file that is only created once the first
+// value is written.
+type lazyEncoder struct {
+	fname string
+	f     *os.File
+	enc   *json.Encoder
+}
+
+func (l *lazyEncoder) Encode(v any) {
+	if l.enc == nil {
+		l.f = openOutput(l.fname)
+		l.enc = json.NewEncoder(l.f)
+	}
+	if err := l.enc.Encode(v); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (l *lazyEncoder) Close() {
+	if l.f != nil {
+		l.f.Close()
+	}
+}
+
+// preservedFields are the JSON keys of entry fields that refreshing copies
+// from the existing entry rather than taking from Flickr.
+var preservedFields []string
+
+func parsePreservedFields(s string) []string {
+	var fields []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
 			continue
 		}
+		if !hasJSONField(reflect.TypeFor[Entry](), name) {
+			log.Fatalf("Invalid -preserve-fields: entries have no field %q", name)
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+var errMediaNotReady = errors.New("media is still being processed")
+
+type Failure struct {
+	Id     string `json:"id"`
+	Reason string `json:"reason"`
+}
 
-		entry := createEntry(id)
-		if err := outEnc.Encode(entry); err != nil {
+func processRegion(ctx context.Context, region string, ids []string, out *entryWriter, existingEntries map[string]Entry) (*RegionStats, error) {
+	log.Printf("Processing region %s", region)
+	stats := newRegionStats()
+	startCalls := apiCalls.Load()
+	defer func() { stats.APICalls = apiCalls.Load() - startCalls }()
+	progress.startRegion(region, len(ids))
+	defer progress.finishRegion(stats)
+
+	failedFname := outPath(region + ".failed.ndjson")
+	if *resumeFailed {
+		// The ids being retried came from this file, so whatever still fails
+		// replaces it.
+		if err := os.Remove(failedFname); err != nil && !errors.Is(err, os.ErrNotExist) {
 			log.Fatal(err)
 		}
+		defer func() {
+			log.Printf("Resumed %d failed ids in %s: %d written, %d still failing",
+				len(ids), region, stats.Written, stats.Failed)
+		}()
+	}
+	failures := &lazyEncoder{fname: failedFname}
+	defer failures.Close()
+	skipped := &lazyEncoder{fname: outPath(region + ".skipped.ndjson")}
+	defer skipped.Close()
+	changes := &lazyEncoder{fname: outPath(region + ".changes.ndjson")}
+	defer changes.Close()
+	dead := loadDeadCache(region, *deadTTL)
+	defer dead.save()
+	moved := &lazyEncoder{fname: outPath(region + ".moved.ndjson")}
+	defer moved.Close()
+	geoMismatches := &lazyEncoder{fname: outPath(region + ".geo-mismatch.ndjson")}
+	defer geoMismatches.Close()
+	if *checksumImages {
+		defer writeDuplicates(region, existingEntries)
+	}
+	dedupe := dedupeIndex(existingEntries)
+	var index *seenIndex
+	if indexed() {
+		index = openSeenIndex(region)
+		defer index.Close()
+	}
+	tierFiles := make(map[string]*lazyEncoder)
+	if *splitBySize {
+		for _, tier := range sizeTiers {
+			tierFiles[tier.name] = &lazyEncoder{fname: outPath(region + ".sizes-" + tier.name + ".ndjson")}
+			defer tierFiles[tier.name].Close()
+		}
+	}
+
+	var prefetch *prefetcher
+	if *prefetchAhead > 0 {
+		var want []int
+		for i, id := range ids {
+			if existing, ok := existingEntries[id]; (!ok || needsRefetch(existing)) && !index.has(id) && !dead.isDead(id) {
+				want = append(want, i)
+			}
+		}
+		prefetchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		prefetch = startPrefetch(prefetchCtx, region, ids, want, *prefetchAhead)
+	}
+
+	for i, id := range ids {
+		progress.update(i, stats)
+		if ctx.Err() != nil {
+			recordInterrupted(failures, ids[i:], existingEntries, index)
+			return stats, nil
+		}
+
+		existing, isExisting := existingEntries[id]
+		if isExisting && !needsRefetch(existing) || index.has(id) {
+			continue
+		}
+
+		if dead.isDead(id) {
+			// Already recorded as a failure when it was first found dead.
+			stats.Skipped[SkipKnownDead]++
+			continue
+		}
+
+		var entry Entry
+		var skip SkipReason
+		var err error
+		if prefetch != nil {
+			entry, skip, err = prefetch.take(i, id)
+		} else {
+			entry, skip, err = hydrate(ctx, region, id)
+		}
+
+		if err != nil && ctx.Err() != nil {
+			recordInterrupted(failures, ids[i:], existingEntries, index)
+			return stats, nil
+		}
+		if err != nil {
+			reason := err.Error()
+			if errors.Is(err, context.DeadlineExceeded) {
+				reason = "photo-timeout"
+			} else if errors.Is(err, errMediaNotReady) {
+				reason = "media-not-ready"
+			} else if isNotFound(err) {
+				dead.markDead(id)
+				if *followMoved && isExisting {
+					if movedTo, err := findMovedPhoto(ctx, existing); err != nil {
+						log.Printf("Failed to look for where %s moved to: %s", id, err)
+					} else if movedTo != "" {
+						log.Printf("Photo %s appears to have moved to %s", id, movedTo)
+						moved.Encode(MovedPhoto{Id: id, MovedTo: movedTo})
+					}
+				}
+			}
+			log.Printf("Failed to hydrate %s: %s", id, reason)
+			failures.Encode(Failure{Id: id, Reason: reason})
+			reportError(region, id, err)
+			stats.Failed++
+			if *failFast {
+				// As when interrupted, so that the ids not got to aren't
+				// lost from a -resume-failed run's failures file.
+				recordInterrupted(failures, ids[i+1:], existingEntries, index)
+				return stats, fmt.Errorf("hydrating %s: %w", id, err)
+			}
+			continue
+		}
+
+		if skip != NotSkipped {
+			log.Printf("Skipping %s: %s", id, skip)
+			skipped.Encode(Skip{Id: id, Reason: skip})
+			stats.Skipped[skip]++
+			continue
+		}
+
+		if mismatch, ok := checkExpectedCoords(entry); ok {
+			// The id may have been reused or the photo moved since the
+			// ingest list was made.
+			log.Printf("Skipping %s: %.1fkm from where the ingest file expected it", id, mismatch.DistanceKm)
+			geoMismatches.Encode(mismatch)
+			stats.Skipped[SkipGeoMismatch]++
+			continue
+		}
+
+		entry.Region = region
+		entry.RetrievedAt = time.Now().UTC()
+		if *noSizes && isExisting {
+			entry.Sizes = existing.Sizes
+			entry.SizeCount = existing.SizeCount
+			entry.MaxWidth = existing.MaxWidth
+			entry.MaxHeight = existing.MaxHeight
+			entry.OrientedWidth = existing.OrientedWidth
+			entry.OrientedHeight = existing.OrientedHeight
+			entry.Orientation = existing.Orientation
+			entry.AspectRatio = existing.AspectRatio
+			entry.DisplayURL = existing.DisplayURL
+		}
+		if isExisting {
+			copyFields(&entry, existing, preservedFields)
+		}
+
+		entry.ContentHash = contentHash(entry)
+
+		if isExisting {
+			existingHash := existing.ContentHash
+			if existingHash == "" {
+				existingHash = contentHash(existing)
+			}
+			if existingHash == entry.ContentHash {
+				// Leaving the file alone means the entry stays stale and will
+				// be checked again next run, but downstream syncs see no churn.
+				continue
+			}
+			if fieldChanges := diffFields(existing, entry, "retrievedAt", "contentHash", "provenance"); len(fieldChanges) > 0 {
+				changes.Encode(EntryChanges{Id: id, Changes: fieldChanges})
+			}
+		}
+
+		key := dedupeKey(entry)
+		if other, ok := dedupe[key]; ok && other != id {
+			log.Printf("Skipping %s: duplicate of %s by %s", id, other, *dedupeBy)
+			skipped.Encode(Skip{Id: id, Reason: SkipDuplicate})
+			stats.Skipped[SkipDuplicate]++
+			continue
+		}
+		dedupe[key] = id
+
+		out.Write(entry)
+		existingEntries[id] = entry
+		index.add(id)
+		stats.Written++
+		if isExisting {
+			stats.Refetched++
+		}
+
+		for tier, size := range tierSizes(entry.Sizes) {
+			if f, ok := tierFiles[tier]; ok {
+				f.Encode(TierSize{Id: id, URL: size.Source})
+			}
+		}
+	}
+	return stats, nil
+}
+
+// hydrate creates the entry for a photo under -photo-timeout, tracing it. A
+// photo that runs out of time fails with an error wrapping
+// context.DeadlineExceeded.
+func hydrate(ctx context.Context, region, id string) (Entry, SkipReason, error) {
+	photoCtx, cancel := ctx, context.CancelFunc(func() {})
+	if *photoTimeout > 0 {
+		photoCtx, cancel = withPhotoTimeout(ctx, *photoTimeout)
+	}
+	defer cancel()
+	photoCtx, photoSpan := startSpan(photoCtx, "createEntry", spanKindInternal)
+	photoSpan.set("photo_id", id)
+	photoSpan.set("region", region)
+	entry, skip, err := createEntry(photoCtx, id)
+	if err != nil && ctx.Err() == nil && timedOut(photoCtx) {
+		err = fmt.Errorf("%w after %s: %w", context.DeadlineExceeded, *photoTimeout, err)
+	}
+	switch {
+	case err != nil:
+		photoSpan.set("outcome", "failed")
+	case skip != NotSkipped:
+		photoSpan.set("outcome", "skipped:"+string(skip))
+	default:
+		photoSpan.set("outcome", "hydrated")
+	}
+	photoSpan.End(err)
+	return entry, skip, err
+}
+
+// needsRefetch reports whether an existing entry should be hydrated again,
+// because it is older than -refresh-older-than or lacks the
+// -refetch-if-missing field.
+func needsRefetch(existing Entry) bool {
+	if *refreshAge != 0 && time.Since(existing.RetrievedAt) >= *refreshAge {
+		return true
+	}
+	return *refetchMissing != "" && fieldMissing(existing, *refetchMissing)
+}
+
+// recordInterrupted records the ids a shutdown prevented us from hydrating.
+func recordInterrupted(failures *lazyEncoder, ids []string, existingEntries map[string]Entry, index *seenIndex) {
+	for _, id := range ids {
+		if _, ok := existingEntries[id]; !ok && !index.has(id) {
+			failures.Encode(Failure{Id: id, Reason: "interrupted"})
+		}
 	}
 }
 
 func parseIngest(fname string) []string {
+	ids, _ := parseIngestFrom(fname, 0)
+	return ids
+}
+
+// parseIngestFrom parses the ids in an ingest file from a byte offset,
+// returning them and the offset of the end of the last one.
+func parseIngestFrom(fname string, offset int64) ([]string, int64) {
 	f, err := os.Open(fname)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer f.Close()
 
+	if offset > 0 {
+		if info, err := f.Stat(); err != nil {
+			log.Fatal(err)
+		} else if info.Size() < offset {
+			log.Printf("Warning: %s is shorter than when last read, so reading it from the start", fname)
+			offset = 0
+		}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Fatal(err)
+	}
+
 	dec := json.NewDecoder(f)
 	var ids []string
 	for {
-		var id string
-		err := dec.Decode(&id)
+		var line json.RawMessage
+		err := dec.Decode(&line)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			log.Fatal(err)
 		}
+		id, err := parseIngestLine(line)
+		if err != nil {
+			log.Fatalf("%s: %s", fname, err)
+		}
 		ids = append(ids, id)
 	}
-	return ids
+	return rejectNonPhotoIds(fname, ids), offset + dec.InputOffset()
 }
 
-func parseExisting(region string) map[string]Entry {
-	f, err := os.Open("out/" + region + ".ndjson")
+// rejectNonPhotoIds drops ids that can't be photo ids, since Flickr's photo
+// ids are numeric. An owner NSID such as 12345678@N00 or a group id pasted in
+// by mistake would otherwise cost a call just to fail. With -strict, any such
+// id is fatal.
+func rejectNonPhotoIds(source string, ids []string) []string {
+	var valid []string
+	var rejected []string
+	for _, id := range ids {
+		if id != "" && strings.Trim(id, "0123456789") == "" {
+			valid = append(valid, id)
+		} else {
+			rejected = append(rejected, id)
+		}
+	}
+	if len(rejected) > 0 {
+		if *strict {
+			log.Fatalf("%s has %d ids that aren't photo ids, such as %q", source, len(rejected), rejected[0])
+		}
+		log.Printf("Warning: skipping %d ids in %s that aren't photo ids, such as %q", len(rejected), source, rejected[0])
+	}
+	return valid
+}
+
+func parseExisting(fname string) map[string]Entry {
+	entries := make(map[string]Entry)
+	for _, entry := range readEntries(fname) {
+		entries[entry.Id] = entry
+	}
+	return entries
+}
+
+// readEntries returns every entry in an output file in order, including
+// superseded entries for the same id.
+func readEntries(fname string) []Entry {
+	f, err := os.Open(fname)
 	if errors.Is(err, os.ErrNotExist) {
 		return nil
 	}
@@ -108,45 +1235,171 @@ func parseExisting(region string) map[string]Entry {
 	}
 	defer f.Close()
 
+	var entries []Entry
 	dec := json.NewDecoder(f)
-	entries := make(map[string]Entry)
 	for {
-		var entry Entry
-		err := dec.Decode(&entry)
+		var line json.RawMessage
+		err := dec.Decode(&line)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			log.Fatal(err)
 		}
-		entries[entry.Id] = entry
+		if isMetaLine(line) {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			log.Fatal(err)
+		}
+		entries = append(entries, entry)
 	}
 	return entries
 }
 
 type PictureSize struct {
-	Label  string `json:"label"`
-	Width  int    `json:"width"`
-	Height int    `json:"height"`
-	Source string `json:"source"`
+	Label          string `json:"label"`
+	CanonicalLabel string `json:"canonicalLabel,omitempty"`
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
+	Source         string `json:"source"`
 }
 
 type Entry struct {
 	Id                  string        `json:"id"`
 	Sizes               []PictureSize `json:"sizes"`
+	SizeCount           int           `json:"sizeCount"`
+	MaxWidth            int           `json:"maxWidth"`
+	MaxHeight           int           `json:"maxHeight"`
+	Rotation            int           `json:"rotation,omitempty"`       // Degrees clockwise to display the stored image at
+	OrientedWidth       int           `json:"orientedWidth,omitempty"`  // maxWidth as displayed, after rotation
+	OrientedHeight      int           `json:"orientedHeight,omitempty"` // maxHeight as displayed, after rotation
+	Orientation         string        `json:"orientation,omitempty"`    // landscape, portrait or square, after rotation
+	AspectRatio         float64       `json:"aspectRatio,omitempty"`    // Width over height, after rotation
+	DisplayURL          string        `json:"displayUrl,omitempty"`     // Chosen by -display-strategy
+	OwnerNSID           string        `json:"ownerNsid"`
 	OwnerUsername       string        `json:"ownerUsername"`
 	OwnerIcon           string        `json:"ownerIcon"`
 	Title               string        `json:"title"`
 	Description         string        `json:"description"`
+	DescriptionHTML     string        `json:"descriptionHTML,omitempty"`
+	TitleFull           string        `json:"titleFull,omitempty"`       // Untruncated, with -keep-full-text
+	DescriptionFull     string        `json:"descriptionFull,omitempty"` // Untruncated, with -keep-full-text
 	DateTaken           string        `json:"dateTaken"`
+	DatePosted          time.Time     `json:"datePosted"` // Upload time, as opposed to DateTaken
 	Latitude            string        `json:"latitude"`
 	Longitude           string        `json:"longitude"`
-	LocationAccuracy    string        `json:"locationAccuracy"`
+	LatitudeF           *float64      `json:"latitudeF,omitempty"`
+	LongitudeF          *float64      `json:"longitudeF,omitempty"`
+	HasLocation         *bool         `json:"hasLocation,omitempty"` // Set on every entry with -keep-unlocated
+	LocationAccuracy    flexInt       `json:"locationAccuracy"`
 	LocationDescription string        `json:"locationDescription"`
+	LocationParts       *Places       `json:"locationParts,omitempty"`
+	Woeid               string        `json:"woeid,omitempty"`
+	Lang                string        `json:"lang,omitempty"` // The -lang place names were requested in
 	Webpage             string        `json:"url"`
+	License             string        `json:"license"`
+	Usage               *Usage        `json:"usage,omitempty"`
+	LicenseName         string        `json:"licenseName,omitempty"`
+	LicenseURL          string        `json:"licenseUrl,omitempty"`
+	Attribution         string        `json:"attribution,omitempty"`
+	MediaStatus         string        `json:"mediaStatus,omitempty"`
+	ContentType         int           `json:"contentType,omitempty"` // 1 photo, 2 screenshot, 3 other; 0 if Flickr didn't say
+	Views               int           `json:"views"`
+	LocationIsPublic    bool          `json:"locationIsPublic,omitempty"`
+	Region              string        `json:"region,omitempty"`
+	RetrievedAt         time.Time     `json:"retrievedAt"`
+	ContentHash         string        `json:"contentHash"`
+	Favorites           int           `json:"favorites,omitempty"`
+	FavoritedBy         []Owner       `json:"favoritedBy"` // One page sample, null unless fetched
+	Notes               []Note        `json:"notes"`       // Null unless -with-notes
+	Exif                *Exif         `json:"exif,omitempty"`
+	Tags                []string      `json:"tags,omitempty"`
+	Comments            []Comment     `json:"comments,omitempty"`
+	Groups              []Group       `json:"groups,omitempty"`
+	OwnerRealName       string        `json:"ownerRealName,omitempty"`
+	OwnerLocation       string        `json:"ownerLocation,omitempty"`
+	PartialEnrichments  []string      `json:"partialEnrichments,omitempty"`
+	ImageHash           string        `json:"imageHash,omitempty"` // SHA-256 of the thumbnail, with -checksum-images
+	Provenance          *Provenance   `json:"provenance,omitempty"`
+	// Annotations added outside the hydrator, which refreshes keep
+	Local map[string]any `json:"local,omitempty"`
 }
 
-func createEntry(id string) Entry {
+type Owner struct {
+	NSID     string `json:"nsid"`
+	Username string `json:"username"`
+}
+
+// SkipReason explains why a photo was deliberately left out of the output.
+type SkipReason string
+
+const (
+	NotSkipped            SkipReason = ""
+	SkipLocationNotPublic SkipReason = "location-not-public"
+	SkipKnownDead         SkipReason = "known-dead"
+	SkipTooSmall          SkipReason = "too-small"
+	SkipTooFewViews       SkipReason = "too-few-views"
+	SkipTooFewFavorites   SkipReason = "too-few-favorites"
+	SkipWoeidFiltered     SkipReason = "woeid-filtered"
+	SkipContentType       SkipReason = "content-type"
+	SkipDuplicate         SkipReason = "duplicate"
+	SkipUsageRestricted   SkipReason = "usage-restricted"
+	SkipNoLocation        SkipReason = "no-location"
+	SkipGeoMismatch       SkipReason = "geo-mismatch"
+)
+
+type Skip struct {
+	Id     string     `json:"id"`
+	Reason SkipReason `json:"reason"`
+}
+
+type flickrLocation struct {
+	Latitude     string      `json:"latitude"`
+	Longitude    string      `json:"longitude"`
+	Accuracy     flexInt     `json:"accuracy"`
+	Woeid        flexString  `json:"woeid"`
+	Neighborhood flickrPlace `json:"neighborhood"`
+	Locality     flickrPlace `json:"locality"`
+	County       flickrPlace `json:"county"`
+	Region       flickrPlace `json:"region"`
+	Country      flickrPlace `json:"country"`
+}
+
+type flickrPlace struct {
+	Content string     `json:"_content"`
+	PlaceId string     `json:"place_id"`
+	Woeid   flexString `json:"woeid"`
+}
+
+// Hosts -cdn can build static URLs on. Sizes' sources are as Flickr gives
+// them, unless missing.
+const (
+	cdnFarm = "farm" // farmN.staticflickr.com
+	cdnLive = "live" // live.staticflickr.com
+)
+
+// buddyIconURL returns the URL of an owner's buddy icon on the -cdn host, or
+// Flickr's default icon if they haven't set one.
+func buddyIconURL(farm int, server, nsid string) string {
+	if server == "0" {
+		return "https://www.flickr.com/images/buddyicon.gif"
+	}
+	return staticURL(farm, server, "buddyicons/"+nsid+".jpg")
+}
+
+// staticURL returns the URL of a file on a static server, on the -cdn host.
+func staticURL(farm int, server, name string) string {
+	if *cdn == cdnLive {
+		return "https://live.staticflickr.com/" + server + "/" + name
+	}
+	return "https://farm" + fmt.Sprintf("%d", farm) + ".staticflickr.com/" + server + "/" + name
+}
+
+func createEntry(ctx context.Context, id string) (Entry, SkipReason, error) {
+	ctx, calls := withProvenance(ctx)
+
 	var info struct {
 		Photo struct {
 			Owner struct {
@@ -155,6 +1408,20 @@ func createEntry(id string) Entry {
 				IconServer string `json:"iconserver"`
 				IconFarm   int    `json:"iconfarm"`
 			} `json:"owner"`
+			License        string       `json:"license"`
+			Server         string       `json:"server"`
+			Farm           int          `json:"farm"`
+			Secret         string       `json:"secret"`
+			OriginalSecret string       `json:"originalsecret"`
+			OriginalFormat string       `json:"originalformat"`
+			MediaStatus    string       `json:"media_status"`
+			ContentType    flexInt      `json:"content_type"`
+			Rotation       flexInt      `json:"rotation"`
+			Usage          *flickrUsage `json:"usage"`
+			Notes          struct {
+				Note []flickrNote `json:"note"`
+			} `json:"notes"`
+			Views flexInt `json:"views"`
 			Title struct {
 				Content string `json:"_content"`
 			} `json:"title"`
@@ -162,29 +1429,11 @@ func createEntry(id string) Entry {
 				Content string `json:"_content"`
 			} `json:"description"`
 			Dates struct {
-				Taken string `json:"taken"`
+				Taken  string `json:"taken"`
+				Posted string `json:"posted"`
 			} `json:"dates"`
-			Location struct {
-				Latitude     string `json:"latitude"`
-				Longitude    string `json:"longitude"`
-				Accuracy     string `json:"accuracy"`
-				Neighborhood struct {
-					Content string `json:"_content"`
-				} `json:"neighborhood"`
-				Locality struct {
-					Content string `json:"_content"`
-				} `json:"locality"`
-				County struct {
-					Content string `json:"_content"`
-				} `json:"county"`
-				Region struct {
-					Content string `json:"_content"`
-				} `json:"region"`
-				Country struct {
-					Content string `json:"_content"`
-				} `json:"country"`
-			} `json:"location"`
-			URLs struct {
+			Location flickrLocation `json:"location"`
+			URLs     struct {
 				URL []struct {
 					Type    string `json:"type"`
 					Content string `json:"_content"`
@@ -192,20 +1441,122 @@ func createEntry(id string) Entry {
 			} `json:"urls"`
 		} `json:"photo"`
 	}
-	callFlickr("flickr.photos.getInfo", &info, map[string]string{"photo_id": id})
+	if err := callFlickr(ctx, "flickr.photos.getInfo", &info, localized(map[string]string{"photo_id": id})); err != nil {
+		return Entry{}, NotSkipped, err
+	}
+
+	if info.Photo.MediaStatus != "" && info.Photo.MediaStatus != "ready" {
+		// Sizes aren't available until processing finishes, so try again
+		// later rather than recording a half-processed photo.
+		return Entry{}, NotSkipped, errMediaNotReady
+	}
+
+	if !contentTypeAllowed(int(info.Photo.ContentType)) {
+		return Entry{}, SkipContentType, nil
+	}
+
+	usage := info.Photo.Usage.usage()
+	if !usageAllowed(usage) {
+		return Entry{}, SkipUsageRestricted, nil
+	}
+
+	if int(info.Photo.Views) < *minViews {
+		return Entry{}, SkipTooFewViews, nil
+	}
+
+	// The owner is only known once getInfo returns, so the per-owner limit
+	// paces the calls that follow it.
+	if err := owners.Wait(ctx, info.Photo.Owner.NSID, *ownerPacing); err != nil {
+		return Entry{}, NotSkipped, err
+	}
+
+	if *geoFallback && info.Photo.Location.Latitude == "" {
+		location, err := fetchGeoLocation(ctx, id)
+		if err != nil {
+			return Entry{}, NotSkipped, err
+		}
+		if location != nil {
+			info.Photo.Location = *location
+		}
+	}
+
+	if !woeidAllowed(info.Photo.Location) {
+		return Entry{}, SkipWoeidFiltered, nil
+	}
+
+	var locationIsPublic bool
+	if *publicOnly && info.Photo.Location.Latitude != "" {
+		var perms struct {
+			Perms struct {
+				IsPublic int `json:"ispublic"`
+			} `json:"perms"`
+		}
+		if err := callFlickr(ctx, "flickr.photos.geo.getPerms", &perms, map[string]string{"photo_id": id}); err != nil {
+			return Entry{}, NotSkipped, err
+		}
+		if perms.Perms.IsPublic != 1 {
+			return Entry{}, SkipLocationNotPublic, nil
+		}
+		locationIsPublic = true
+	}
 
 	var sizes struct {
 		Sizes struct {
 			Size []PictureSize `json:"size"`
 		}
 	}
-	callFlickr("flickr.photos.getSizes", &sizes, map[string]string{"photo_id": id})
+	if !*noSizes {
+		if err := callFlickr(ctx, "flickr.photos.getSizes", &sizes, map[string]string{"photo_id": id}); err != nil {
+			return Entry{}, NotSkipped, err
+		}
+	}
+
+	photoFiles := photoFiles{id: id, server: info.Photo.Server, farm: info.Photo.Farm, secret: info.Photo.Secret,
+		originalSecret: info.Photo.OriginalSecret, originalFormat: info.Photo.OriginalFormat}
+	if repaired := repairSources(sizes.Sizes.Size, photoFiles); repaired > 0 {
+		log.Printf("Rebuilt the missing source of %d sizes of %s", repaired, id)
+	}
+	pictureSizes := validSizes(sizes.Sizes.Size)
+	if dropped := len(sizes.Sizes.Size) - len(pictureSizes); dropped > 0 {
+		log.Printf("Dropped %d degenerate sizes of %s", dropped, id)
+	}
+
+	largest, hasSizes := largestSize(pictureSizes)
+	if hasSizes && largest.Width < *minWidth {
+		return Entry{}, SkipTooSmall, nil
+	}
+
+	var displayURL string
+	rotation := int(info.Photo.Rotation)
+	var orientedWidth, orientedHeight int
+	var photoOrientation string
+	var aspectRatio float64
+	if hasSizes {
+		orientedWidth, orientedHeight = orientedDimensions(largest, rotation)
+		photoOrientation = orientation(orientedWidth, orientedHeight)
+		aspectRatio = float64(orientedWidth) / float64(orientedHeight)
+	}
+	if display, ok := pickSize(pictureSizes, rotation); ok {
+		displayURL = display.Source
+	}
+
+	if *canonLabels {
+		for i := range pictureSizes {
+			pictureSizes[i].CanonicalLabel = canonicalSizeLabel(pictureSizes[i].Label)
+		}
+	}
 
-	ownerIcon := "https://www.flickr.com/images/buddyicon.gif"
-	if info.Photo.Owner.IconServer != "0" {
-		ownerIcon = "https://farm" + fmt.Sprintf("%d", info.Photo.Owner.IconFarm) + ".staticflickr.com/" + info.Photo.Owner.IconServer + "/buddyicons/" + info.Photo.Owner.NSID + ".jpg"
+	var datePosted time.Time
+	if info.Photo.Dates.Posted != "" {
+		posted, err := strconv.ParseInt(info.Photo.Dates.Posted, 10, 64)
+		if err != nil {
+			return Entry{}, NotSkipped, fmt.Errorf("parsing posted date of %s: %w", id, err)
+		}
+		datePosted = time.Unix(posted, 0).UTC()
 	}
 
+	ownerIcon := buddyIconURL(info.Photo.Owner.IconFarm, info.Photo.Owner.IconServer, info.Photo.Owner.NSID)
+
 	potentialLocationSegments := []string{
 		info.Photo.Location.Neighborhood.Content, info.Photo.Location.Locality.Content,
 		info.Photo.Location.County.Content, info.Photo.Location.Region.Content, info.Photo.Location.Country.Content}
@@ -217,30 +1568,190 @@ func createEntry(id string) Entry {
 	}
 	locationDescription := strings.Join(locationSegments, ", ")
 
+	title := info.Photo.Title.Content
+	description := info.Photo.Description.Content
+	var descriptionHTML string
+	if *stripMarkup {
+		descriptionHTML = description
+		title = stripHTML(title)
+		description = stripHTML(description)
+	}
+	var titleFull, descriptionFull string
+	if *maxTextLength > 0 {
+		fullTitle, fullDescription := title, description
+		var truncated bool
+		if title, truncated = truncateRunes(title, *maxTextLength); truncated && *keepFullText {
+			titleFull = fullTitle
+		}
+		if description, truncated = truncateRunes(description, *maxTextLength); truncated && *keepFullText {
+			descriptionFull = fullDescription
+		}
+	}
+
 	webpage := "https://flickr.com/photos/" + info.Photo.Owner.NSID
 	if len(info.Photo.URLs.URL) > 0 {
 		webpage = info.Photo.URLs.URL[0].Content
 	}
 
-	return Entry{
+	entry := Entry{
 		Id:                  id,
-		Sizes:               sizes.Sizes.Size,
+		Sizes:               pictureSizes,
+		SizeCount:           len(pictureSizes),
+		MaxWidth:            largest.Width,
+		MaxHeight:           largest.Height,
+		Rotation:            rotation,
+		OrientedWidth:       orientedWidth,
+		OrientedHeight:      orientedHeight,
+		Orientation:         photoOrientation,
+		AspectRatio:         aspectRatio,
+		DisplayURL:          displayURL,
+		OwnerNSID:           info.Photo.Owner.NSID,
 		OwnerUsername:       info.Photo.Owner.Username,
 		OwnerIcon:           ownerIcon,
-		Title:               info.Photo.Title.Content,
-		Description:         info.Photo.Description.Content,
+		Title:               title,
+		Description:         description,
+		DescriptionHTML:     descriptionHTML,
+		TitleFull:           titleFull,
+		DescriptionFull:     descriptionFull,
 		DateTaken:           info.Photo.Dates.Taken,
+		DatePosted:          datePosted,
 		Latitude:            info.Photo.Location.Latitude,
 		Longitude:           info.Photo.Location.Longitude,
 		LocationAccuracy:    info.Photo.Location.Accuracy,
 		LocationDescription: locationDescription,
+		LocationParts:       locationParts(info.Photo.Location),
+		Woeid:               string(info.Photo.Location.Woeid),
+		Lang:                *lang,
 		Webpage:             webpage,
+		License:             info.Photo.License,
+		Usage:               usage,
+		MediaStatus:         info.Photo.MediaStatus,
+		ContentType:         int(info.Photo.ContentType),
+		Views:               int(info.Photo.Views),
+		LocationIsPublic:    locationIsPublic,
+	}
+
+	if *withNotes {
+		entry.Notes = notes(info.Photo.Notes.Note)
+	}
+
+	parseCoordinates(&entry)
+	hasLocation := entry.LatitudeF != nil
+	if *requireLocation && !hasLocation {
+		return Entry{}, SkipNoLocation, nil
+	}
+	if *keepUnlocated {
+		entry.HasLocation = &hasLocation
+	}
+
+	// A failed enrichment doesn't cost us the photo: it is noted on the entry
+	// so that a later run can top it up.
+	for _, name := range enabledEnrichments {
+		if err := enrichments[name].Apply(ctx, id, &entry); err != nil {
+			if ctx.Err() != nil {
+				return Entry{}, NotSkipped, err
+			}
+			log.Printf("Warning: %s enrichment of %s failed: %s", name, id, err)
+			entry.PartialEnrichments = append(entry.PartialEnrichments, name)
+		}
+	}
+
+	if *checksumImages {
+		hash, err := imageHash(ctx, entry.Sizes)
+		if err != nil {
+			return Entry{}, NotSkipped, fmt.Errorf("hashing image: %w", err)
+		}
+		entry.ImageHash = hash
+	}
+
+	if *minFavorites > 0 && slices.Contains(entry.PartialEnrichments, "favorites") {
+		return Entry{}, NotSkipped, errors.New("favorites are needed for -min-favorites but could not be fetched")
+	}
+	if entry.Favorites < *minFavorites {
+		return Entry{}, SkipTooFewFavorites, nil
+	}
+
+	describeLicense(&entry)
+	entry.Provenance = calls.provenance()
+
+	return entry, NotSkipped, nil
+}
+
+// contentHash hashes everything about an entry except when and how it was
+// retrieved, so that refetching an unchanged photo gives the same hash.
+func contentHash(entry Entry) string {
+	entry.RetrievedAt = time.Time{}
+	entry.ContentHash = ""
+	entry.Provenance = nil
+	// encoding/json writes struct fields in declaration order and map keys
+	// sorted, so the encoding is deterministic. Hashing the plain encoding
+	// keeps the hash the same whatever -empty-policy is.
+	data, err := json.Marshal(plainEntry(entry))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// validSizes filters out sizes with non-positive dimensions or no source.
+func validSizes(sizes []PictureSize) []PictureSize {
+	var valid []PictureSize
+	for _, size := range sizes {
+		if size.Width > 0 && size.Height > 0 && size.Source != "" {
+			valid = append(valid, size)
+		}
+	}
+	return valid
+}
+
+// localized adds -lang to the parameters of a call that returns place names.
+// Flickr falls back to its default language for places without a translation,
+// and those names are kept as returned.
+func localized(params map[string]string) map[string]string {
+	if *lang != "" {
+		params["lang"] = *lang
+	}
+	return params
+}
+
+// fetchGeoLocation returns nil if the photo has no location.
+func fetchGeoLocation(ctx context.Context, id string) (*flickrLocation, error) {
+	var resp struct {
+		Photo struct {
+			Location flickrLocation `json:"location"`
+		} `json:"photo"`
+	}
+	err := callFlickr(ctx, "flickr.photos.geo.getLocation", &resp, localized(map[string]string{"photo_id": id}))
+	var flickrErr *FlickrError
+	if errors.As(err, &flickrErr) && flickrErr.Code == 2 {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
+	return &resp.Photo.Location, nil
+}
+
+// Doer sends HTTP requests. callFlickr depends on it rather than on
+// *http.Client directly so that responses can be scripted.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
 }
 
-func callFlickr(method string, resp any, params map[string]string) {
+var httpClient Doer = &http.Client{}
+
+type FlickrError struct {
+	Method  string
+	Code    int
+	Message string
+}
+
+func (e *FlickrError) Error() string {
+	return fmt.Sprintf("%s: error %d: %s", e.Method, e.Code, e.Message)
+}
+
+func callFlickr(ctx context.Context, method string, resp any, params map[string]string) error {
 	params["method"] = method
-	params["api_key"] = flickrAPIKey
 	params["format"] = "json"
 	params["nojsoncallback"] = "1"
 
@@ -258,27 +1769,95 @@ func callFlickr(method string, resp any, params map[string]string) {
 
 	log.Printf("Calling Flickr API: %s", r.String())
 
-	time.Sleep(1 * time.Second)
-
-	httpResp, err := http.Get(r.String())
+	ctx, callSpan := startSpan(ctx, method, spanKindClient)
+	callSpan.set("flickr.method", method)
+	if id, ok := params["photo_id"]; ok {
+		callSpan.set("photo_id", id)
+	}
+	err := withRetries(ctx, method, func() error {
+		return doCallFlickr(ctx, method, r, query, resp)
+	})
 	if err != nil {
-		log.Fatal(err)
+		callSpan.set("outcome", "error")
+	} else {
+		callSpan.set("outcome", "ok")
 	}
-	if httpResp.StatusCode != http.StatusOK {
-		log.Fatalf("HTTP status %d", httpResp.StatusCode)
+	callSpan.End(err)
+	return err
+}
+
+func doCallFlickr(ctx context.Context, method string, r url.URL, query url.Values, resp any) error {
+	if err := requests.Wait(ctx, *hourlyCap*keys.Len()); err != nil {
+		return err
+	}
+	key, err := keys.Acquire(ctx, *hourlyCap)
+	if err != nil {
+		return err
 	}
+	query.Set("api_key", key.key)
+	r.RawQuery = query.Encode()
 
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.String(), nil)
+	if err != nil {
+		return err
+	}
+	apiCalls.Add(1)
+	recordMethod(ctx, method, key)
+	startPhotoClock(ctx)
+	httpResp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
 	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return &HTTPStatusError{Method: method, StatusCode: httpResp.StatusCode}
+	}
 
-	body, err := io.ReadAll(httpResp.Body)
+	// Read one byte past the limit to tell a body of exactly the limit from
+	// one that was cut off.
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, *maxResponse+1))
 	if err != nil {
-		log.Fatal(err)
-		return
+		return err
+	}
+	if int64(len(body)) > *maxResponse {
+		return fmt.Errorf("%s: response is larger than -max-response-bytes (%d)", method, *maxResponse)
 	}
+	body = unwrapBody(method, body)
 
-	err = json.Unmarshal(body, &resp)
-	if err != nil {
-		log.Fatal(err)
-		return
+	var status struct {
+		Stat    string `json:"stat"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return err
+	}
+	if status.Stat != "ok" {
+		return &FlickrError{Method: method, Code: status.Code, Message: status.Message}
+	}
+	if *saveRaw {
+		saveRawResponse(query, body)
+	}
+
+	return json.Unmarshal(body, &resp)
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// unwrapBody undoes mangling some CDNs in front of Flickr have been seen to
+// apply: a leading byte order mark, or the whole JSON document encoded as a
+// JSON string.
+func unwrapBody(method string, body []byte) []byte {
+	if bytes.HasPrefix(body, utf8BOM) {
+		log.Printf("Warning: stripping byte order mark from %s response", method)
+		body = body[len(utf8BOM):]
+	}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '"' {
+		var inner string
+		if err := json.Unmarshal(trimmed, &inner); err == nil {
+			log.Printf("Warning: unquoting double-encoded %s response", method)
+			body = []byte(inner)
+		}
 	}
+	return body
 }
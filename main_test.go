@@ -0,0 +1,856 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// expectFatal checks that fn exits non-zero, as through log.Fatal, having
+// logged a message containing want, by running the test again in a child process that calls
+// fn. Anything the test sets up before calling expectFatal is set up again
+// in the child.
+func expectFatal(t *testing.T, want string, fn func()) {
+	t.Helper()
+	if os.Getenv("HYDRATOR_EXPECT_FATAL") == t.Name() {
+		fn()
+		os.Exit(0)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), "HYDRATOR_EXPECT_FATAL="+t.Name())
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("didn't exit, want it to fail with %q:\n%s", want, out)
+	}
+	if !strings.Contains(string(out), want) {
+		t.Fatalf("failed without %q:\n%s", want, out)
+	}
+}
+
+// useDirs points -ingest-dir and -out-dir at empty directories for the rest
+// of the test.
+func useDirs(t testing.TB) {
+	t.Helper()
+	oldIngest, oldOut := *ingestDir, *outDir
+	t.Cleanup(func() { *ingestDir, *outDir = oldIngest, oldOut })
+	*ingestDir = filepath.Join(t.TempDir(), "ingest")
+	*outDir = filepath.Join(t.TempDir(), "out")
+	for _, dir := range []string{*ingestDir, *outDir} {
+		if err := os.Mkdir(dir, dirMode); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeFile writes lines to fname, each followed by a newline.
+func writeFile(t testing.TB, fname string, lines ...string) {
+	t.Helper()
+	var data []byte
+	for _, line := range lines {
+		data = append(append(data, line...), '\n')
+	}
+	if err := os.WriteFile(fname, data, fileMode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeEntries writes entries to fname, one per line.
+func writeEntries(t testing.TB, fname string, entries ...Entry) {
+	t.Helper()
+	var lines []string
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+	writeFile(t, fname, lines...)
+}
+
+// entryIds returns the ids of entries, in order.
+func entryIds(entries []Entry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.Id
+	}
+	return ids
+}
+
+func TestFilterShardCoversEveryIdOnce(t *testing.T) {
+	var ids []string
+	for i := range 10000 {
+		ids = append(ids, fmt.Sprint(50000000000+i*7919))
+	}
+	for _, n := range []uint32{1, 2, 3, 7, 16} {
+		t.Run(fmt.Sprintf("n=%d", n), func(t *testing.T) {
+			shardOf := make(map[string]uint32)
+			for i := range n {
+				shard := filterShard(ids, i, n)
+				for _, id := range shard {
+					if prev, ok := shardOf[id]; ok {
+						t.Fatalf("%s is in shards %d and %d", id, prev, i)
+					}
+					shardOf[id] = i
+				}
+				// FNV spreads ids evenly enough that no shard is far off
+				// its share.
+				if share := len(ids) / int(n); len(shard) < share*8/10 || len(shard) > share*12/10 {
+					t.Errorf("shard %d has %d ids, want about %d", i, len(shard), share)
+				}
+			}
+			if len(shardOf) != len(ids) {
+				t.Errorf("shards cover %d ids, want %d", len(shardOf), len(ids))
+			}
+		})
+	}
+}
+
+func TestFilterShardIsStable(t *testing.T) {
+	// Machines running different shards must agree on where each id goes, so
+	// the assignment can't change between versions.
+	want := map[string]uint32{"1001": 3, "1002": 2, "53012345678": 3}
+	for id, shard := range want {
+		for i := range uint32(4) {
+			if got := len(filterShard([]string{id}, i, 4)) == 1; got != (i == shard) {
+				t.Errorf("%s in shard %d of 4 is %v, want it only in shard %d", id, i, got, shard)
+			}
+		}
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	tests := []struct {
+		value string
+		i, n  uint32
+	}{
+		{"", 0, 1},
+		{"0/1", 0, 1},
+		{"2/3", 2, 3},
+	}
+	for _, tt := range tests {
+		i, n := parseShard(tt.value)
+		if i != tt.i || n != tt.n {
+			t.Errorf("parseShard(%q) = %d, %d, want %d, %d", tt.value, i, n, tt.i, tt.n)
+		}
+	}
+}
+
+func TestTrimPartialLine(t *testing.T) {
+	long := `{"id":"3","description":"` + strings.Repeat("x", 10000)
+	tests := []struct {
+		name, data, want string
+	}{
+		{"complete", "{\"id\":\"1\"}\n{\"id\":\"2\"}\n", "{\"id\":\"1\"}\n{\"id\":\"2\"}\n"},
+		{"missing final newline", "{\"id\":\"1\"}\n{\"id\":\"2\"", "{\"id\":\"1\"}\n"},
+		{"partial line longer than a read", "{\"id\":\"1\"}\n" + long, "{\"id\":\"1\"}\n"},
+		{"only a partial line", `{"id":"1`, ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "region.ndjson")
+			if err := os.WriteFile(fname, []byte(tt.data), fileMode); err != nil {
+				t.Fatal(err)
+			}
+			trimPartialLine(fname)
+			got, err := os.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnwrapBody(t *testing.T) {
+	const doc = `{"stat":"ok","photo":{"id":"1001"}}`
+	quoted, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, body string
+	}{
+		{"plain", doc},
+		{"byte order mark", "\xef\xbb\xbf" + doc},
+		{"double-encoded", string(quoted)},
+		{"double-encoded with byte order mark and newline", "\xef\xbb\xbf" + string(quoted) + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unwrapBody("flickr.photos.getInfo", []byte(tt.body)); string(got) != doc {
+				t.Errorf("got %q, want %q", got, doc)
+			}
+		})
+	}
+
+	// A string that isn't JSON is left for the caller to fail on.
+	if got := unwrapBody("flickr.photos.getInfo", []byte(`"truncated`)); string(got) != `"truncated` {
+		t.Errorf("got %q, want the body left as it was", got)
+	}
+}
+
+func TestSkipReasons(t *testing.T) {
+	useFixtures(t)
+	tests := []struct {
+		reason SkipReason
+		id     string
+		set    func()
+	}{
+		{SkipTooSmall, "1001", func() { *minWidth = 100000 }},
+		{SkipTooFewViews, "1001", func() { *minViews = 100000 }},
+		{SkipTooFewFavorites, "1001", func() { *minFavorites = 100000 }},
+		{SkipWoeidFiltered, "1001", func() { deniedWoeids = parseWoeids("26351") }},
+		{SkipContentType, "1001", func() { allowedContentTypes = parseContentTypes("2,3") }},
+		{SkipUsageRestricted, "1001", func() { requiredUsage = parseRequiredUsage("print") }},
+		{SkipNoLocation, "1003", func() { *requireLocation = true }},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.reason), func(t *testing.T) {
+			oldWidth, oldViews, oldFavorites, oldLocation := *minWidth, *minViews, *minFavorites, *requireLocation
+			oldDenied, oldTypes, oldUsage := deniedWoeids, allowedContentTypes, requiredUsage
+			defer func() {
+				*minWidth, *minViews, *minFavorites, *requireLocation = oldWidth, oldViews, oldFavorites, oldLocation
+				deniedWoeids, allowedContentTypes, requiredUsage = oldDenied, oldTypes, oldUsage
+			}()
+			tt.set()
+			if *minFavorites > 0 {
+				old := enabledEnrichments
+				defer func() { enabledEnrichments = old }()
+				enabledEnrichments = []string{"favorites"}
+			}
+
+			_, skip, err := createEntry(context.Background(), tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if skip != tt.reason {
+				t.Errorf("got skip %q, want %q", skip, tt.reason)
+			}
+		})
+	}
+}
+
+func TestSkipRecord(t *testing.T) {
+	reasons := []SkipReason{
+		SkipLocationNotPublic, SkipKnownDead, SkipTooSmall, SkipTooFewViews, SkipTooFewFavorites, SkipWoeidFiltered,
+		SkipContentType, SkipDuplicate, SkipUsageRestricted, SkipNoLocation, SkipGeoMismatch,
+	}
+	seen := make(map[SkipReason]bool)
+	for _, reason := range reasons {
+		if reason == NotSkipped || seen[reason] {
+			t.Errorf("reason %q is empty or not unique", reason)
+		}
+		seen[reason] = true
+
+		data, err := json.Marshal(Skip{Id: "1001", Reason: reason})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `{"id":"1001","reason":"` + string(reason) + `"}`; string(data) != want {
+			t.Errorf("skip is written as %s, want %s", data, want)
+		}
+	}
+}
+
+func TestValidSizes(t *testing.T) {
+	sizes := []PictureSize{
+		{Label: "Small", Width: 240, Height: 160, Source: "https://live.staticflickr.com/65535/1001_abc_m.jpg"},
+		{Label: "Zero width", Width: 0, Height: 160, Source: "https://live.staticflickr.com/65535/1001_abc_n.jpg"},
+		{Label: "Zero height", Width: 320, Height: 0, Source: "https://live.staticflickr.com/65535/1001_abc_n.jpg"},
+		{Label: "Negative", Width: -1, Height: 500, Source: "https://live.staticflickr.com/65535/1001_abc.jpg"},
+		{Label: "No source", Width: 640, Height: 427},
+		{Label: "Large", Width: 1024, Height: 683, Source: "https://live.staticflickr.com/65535/1001_abc_b.jpg"},
+	}
+	var got []string
+	for _, size := range validSizes(sizes) {
+		got = append(got, size.Label)
+	}
+	if want := []string{"Small", "Large"}; !slices.Equal(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+	if got := validSizes(sizes[1:5]); len(got) != 0 {
+		t.Errorf("kept %v of only degenerate sizes, want none", got)
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	tests := []struct {
+		failFast     bool
+		wantErr      bool
+		wantWritten  []string
+		wantFailures []string
+	}{
+		{false, false, []string{"1001", "1003"}, []string{"1002"}},
+		// The ids not got to are recorded so that -resume-failed retries them.
+		{true, true, nil, []string{"1002", "1001", "1003"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("fail-fast=%v", tt.failFast), func(t *testing.T) {
+			useFixtures(t)
+			useDirs(t)
+			old := *failFast
+			defer func() { *failFast = old }()
+			*failFast = tt.failFast
+
+			var buf bytes.Buffer
+			out := newEntryWriter(&buf)
+			_, err := processRegion(context.Background(), "region", []string{"1002", "1001", "1003"}, out, make(map[string]Entry))
+			out.Close()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want one: %v", err, tt.wantErr)
+			}
+			if got := entryIds(decodeEntries(buf.Bytes())); !slices.Equal(got, tt.wantWritten) {
+				t.Errorf("wrote %v, want %v", got, tt.wantWritten)
+			}
+			if got := parseFailures(outPath("region.failed.ndjson")); !slices.Equal(got, tt.wantFailures) {
+				t.Errorf("failures are %v, want %v", got, tt.wantFailures)
+			}
+		})
+	}
+}
+
+// signallingDoer sends the process SIGTERM when asked about photo, then
+// leaves that request hanging until it is cancelled.
+type signallingDoer struct {
+	next  Doer
+	photo string
+}
+
+func (d signallingDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.URL.Query().Get("photo_id") != d.photo {
+		return d.next.Do(req)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		return nil, err
+	}
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestSigtermMidRun(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	httpClient = signallingDoer{next: httpClient, photo: "1003"}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	out, existing := openRegionOutput("region")
+	_, err := processRegion(ctx, "region", []string{"1001", "1003", "1006"}, out, existing)
+	out.Close()
+	if err != nil {
+		t.Fatalf("got error %v, want the run to stop cleanly", err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("SIGTERM wasn't delivered")
+	}
+	if got := entryIds(readEntries(outputFile("region"))); !slices.Equal(got, []string{"1001"}) {
+		t.Errorf("output has %v, want the entry written before the signal", got)
+	}
+	if got := parseFailures(outPath("region.failed.ndjson")); !slices.Equal(got, []string{"1003", "1006"}) {
+		t.Errorf("failures are %v, want the ids not finished", got)
+	}
+}
+
+func TestContentHashIgnoresMapOrder(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	local := func(order []string) map[string]any {
+		m := make(map[string]any)
+		for _, k := range order {
+			m[k] = map[string]any{"nested-" + k: k, "n": len(k)}
+		}
+		return m
+	}
+	want := contentHash(Entry{Id: "1001", Local: local(keys)})
+	for i := range 20 {
+		order := slices.Clone(keys)
+		rand.Shuffle(len(order), func(a, b int) { order[a], order[b] = order[b], order[a] })
+		if got := contentHash(Entry{Id: "1001", Local: local(order)}); got != want {
+			t.Fatalf("hash %d is %s after inserting keys in order %v, want %s", i, got, order, want)
+		}
+	}
+}
+
+func TestContentHashFields(t *testing.T) {
+	base := Entry{Id: "1001", Title: "Ridge"}
+	want := contentHash(base)
+	tests := []struct {
+		name    string
+		change  func(*Entry)
+		changed bool
+	}{
+		{"retrievedAt", func(e *Entry) { e.RetrievedAt = time.Now() }, false},
+		{"provenance", func(e *Entry) { e.Provenance = &Provenance{FetchedAt: time.Now()} }, false},
+		{"contentHash", func(e *Entry) { e.ContentHash = want }, false},
+		{"title", func(e *Entry) { e.Title = "Tarn" }, true},
+	}
+	for _, tt := range tests {
+		entry := base
+		tt.change(&entry)
+		if got := contentHash(entry) != want; got != tt.changed {
+			t.Errorf("changing %s changes the hash: %v, want %v", tt.name, got, tt.changed)
+		}
+	}
+}
+
+func TestRefreshUnchangedEntryIsNotRewritten(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	old := *refreshAge
+	defer func() { *refreshAge = old }()
+	*refreshAge = time.Nanosecond
+
+	existing, _, err := createEntry(context.Background(), "1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing.Region = "region"
+	existing.ContentHash = contentHash(existing)
+	var buf bytes.Buffer
+	out := newEntryWriter(&buf)
+	stats, err := processRegion(context.Background(), "region", []string{"1001"}, out, map[string]Entry{"1001": existing})
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 || stats.Written != 0 {
+		t.Errorf("rewrote the unchanged entry: %s", buf.Bytes())
+	}
+	if _, err := os.Stat(outPath("region.changes.ndjson")); !os.IsNotExist(err) {
+		t.Error("logged changes to the unchanged entry")
+	}
+}
+
+func TestSliceIds(t *testing.T) {
+	ids := []string{"1", "2", "3", "4", "5"}
+	tests := []struct {
+		start, end int
+		want       []string
+	}{
+		{0, 0, ids},
+		{1, 5, ids},
+		{2, 4, []string{"2", "3", "4"}},
+		{3, 3, []string{"3"}},
+		{4, 0, []string{"4", "5"}},
+		{0, 2, []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		if got := sliceIds("region", ids, tt.start, tt.end); !slices.Equal(got, tt.want) {
+			t.Errorf("-start %d -end %d: got %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestSliceIdsStartAfterEnd(t *testing.T) {
+	expectFatal(t, "Invalid -start 4 and -end 2", func() { sliceIds("region", []string{"1", "2", "3", "4"}, 4, 2) })
+}
+
+func TestSliceIdsOutOfRange(t *testing.T) {
+	expectFatal(t, "Invalid -start 2 and -end 6 for region region with 5 ids", func() {
+		sliceIds("region", []string{"1", "2", "3", "4", "5"}, 2, 6)
+	})
+}
+
+func TestSchemaHeaderRoundTrip(t *testing.T) {
+	for _, header := range []bool{false, true} {
+		t.Run(fmt.Sprintf("schema-header=%v", header), func(t *testing.T) {
+			old := *schemaHeader
+			defer func() { *schemaHeader = old }()
+			*schemaHeader = header
+			fname := filepath.Join(t.TempDir(), "region.ndjson")
+
+			// Two runs append to the file, and only the first writes a header.
+			for _, id := range []string{"1001", "1003"} {
+				f := openEntries(fname)
+				out := newEntryWriter(f)
+				out.Write(Entry{Id: id, Title: "Title of " + id})
+				out.Close()
+				f.Close()
+			}
+
+			data, err := os.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			var metaLines int
+			for _, line := range lines {
+				if isMetaLine([]byte(line)) {
+					metaLines++
+				}
+			}
+			if header && (metaLines != 1 || !isMetaLine([]byte(lines[0]))) {
+				t.Errorf("file has %d meta lines, want one as its first line:\n%s", metaLines, data)
+			}
+			if !header && metaLines != 0 {
+				t.Errorf("file has %d meta lines, want none:\n%s", metaLines, data)
+			}
+			if got := entryIds(readEntries(fname)); !slices.Equal(got, []string{"1001", "1003"}) {
+				t.Errorf("read entries %v, want [1001 1003]", got)
+			}
+			existing := parseExisting(fname)
+			if len(existing) != 2 || existing["1003"].Title != "Title of 1003" {
+				t.Errorf("parsed existing entries %v, want 1001 and 1003", existing)
+			}
+		})
+	}
+}
+
+// serverDoer sends requests to server rather than to Flickr.
+type serverDoer struct {
+	server *httptest.Server
+}
+
+func (d serverDoer) Do(req *http.Request) (*http.Response, error) {
+	u, err := url.Parse(d.server.URL)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+	return d.server.Client().Do(req)
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	const limit = 1 << 10
+	// Answers with a valid response padded to the size asked for.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		const prefix, suffix = `{"stat":"ok","pad":"`, `"}`
+		w.Write([]byte(prefix + strings.Repeat("x", size-len(prefix)-len(suffix)) + suffix))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{"under the limit", limit - 1, false},
+		{"at the limit", limit, false},
+		{"over the limit", limit + 1, true},
+		{"far over the limit", 100 * limit, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFixtures(t)
+			httpClient = serverDoer{server}
+			old := *maxResponse
+			defer func() { *maxResponse = old }()
+			*maxResponse = limit
+
+			var resp struct{}
+			err := callFlickr(context.Background(), "flickr.test.echo", &resp, map[string]string{"size": strconv.Itoa(tt.size)})
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("a body of %d bytes failed: %s", tt.size, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "larger than -max-response-bytes") {
+				t.Errorf("a body of %d bytes got error %v, want it rejected as too large", tt.size, err)
+			}
+		})
+	}
+}
+
+func TestRefreshPreservesFields(t *testing.T) {
+	fresh := func(t *testing.T) Entry {
+		entry, _, err := createEntry(context.Background(), "1001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entry
+	}
+	note := map[string]any{"curatorNote": "Summit cairn is just out of shot"}
+	tests := []struct {
+		preserve  string
+		wantLocal bool
+		wantTitle string
+	}{
+		{"local", true, ""},
+		{"local,title", true, "Renamed locally"},
+		{"", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run("preserve="+tt.preserve, func(t *testing.T) {
+			useFixtures(t)
+			useDirs(t)
+			oldAge, oldFields := *refreshAge, preservedFields
+			defer func() { *refreshAge, preservedFields = oldAge, oldFields }()
+			*refreshAge = time.Nanosecond
+			preservedFields = parsePreservedFields(tt.preserve)
+
+			existing := fresh(t)
+			flickrTitle := existing.Title
+			existing.Region = "region"
+			existing.Title = "Renamed locally"
+			existing.Local = note
+			// The view count has gone up since, so the entry is rewritten.
+			existing.Views--
+			var buf bytes.Buffer
+			out := newEntryWriter(&buf)
+			_, err := processRegion(context.Background(), "region", []string{"1001"}, out, map[string]Entry{"1001": existing})
+			out.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got Entry
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("%s: %s", buf.Bytes(), err)
+			}
+			if hasLocal := reflect.DeepEqual(got.Local, note); hasLocal != tt.wantLocal {
+				t.Errorf("refreshed entry has local %v, want it kept: %v", got.Local, tt.wantLocal)
+			}
+			wantTitle := tt.wantTitle
+			if wantTitle == "" {
+				wantTitle = flickrTitle
+			}
+			if got.Title != wantTitle {
+				t.Errorf("refreshed entry has title %q, want %q", got.Title, wantTitle)
+			}
+		})
+	}
+}
+
+func TestCheckDirsAllowsSeparateDirs(t *testing.T) {
+	useDirs(t)
+	old := *appendTo
+	defer func() { *appendTo = old }()
+	for _, appendFile := range []string{"", outPath("all.ndjson"), filepath.Join(*ingestDir, "notes.txt")} {
+		*appendTo = appendFile
+		// Fails the test by exiting if it objects.
+		checkDirs()
+	}
+}
+
+func TestCheckDirsRejectsSameDir(t *testing.T) {
+	useDirs(t)
+	old := *outDir
+	defer func() { *outDir = old }()
+	// The same directory, spelled differently.
+	*outDir = filepath.Join(*ingestDir, "..", filepath.Base(*ingestDir))
+	expectFatal(t, "so output would be read as ingest", checkDirs)
+}
+
+func TestCheckDirsRejectsAppendToIngestFile(t *testing.T) {
+	useDirs(t)
+	old := *appendTo
+	defer func() { *appendTo = old }()
+	*appendTo = filepath.Join(*ingestDir, "all.ndjson")
+	expectFatal(t, "is in -ingest-dir", checkDirs)
+}
+
+func TestSelectIds(t *testing.T) {
+	// As listed in the ingest, oldest first and with a duplicate.
+	ids := []string{"1", "2", "3", "2", "4", "5"}
+	tests := []struct {
+		reverse           bool
+		start, end, limit int
+		want              []string
+	}{
+		{false, 0, 0, 0, []string{"1", "2", "3", "4", "5"}},
+		{true, 0, 0, 0, []string{"5", "4", "3", "2", "1"}},
+		{false, 0, 0, 2, []string{"1", "2"}},
+		{true, 0, 0, 2, []string{"5", "4"}},
+		{true, 2, 4, 0, []string{"4", "3", "2"}},
+		{true, 2, 0, 2, []string{"4", "3"}},
+	}
+	for _, tt := range tests {
+		oldReverse, oldStart, oldEnd, oldLimit := *reverse, *start, *end, *limit
+		*reverse, *start, *end, *limit = tt.reverse, tt.start, tt.end, tt.limit
+		got := selectIds("region", slices.Clone(ids))
+		*reverse, *start, *end, *limit = oldReverse, oldStart, oldEnd, oldLimit
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("-reverse=%v -start %d -end %d -limit %d: got %v, want %v", tt.reverse, tt.start, tt.end, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestRejectNonPhotoIds(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"1001", true},
+		{"53012345678", true},
+		{"12345678@N00", false}, // An owner NSID
+		{"1234567@N21", false},  // A group id
+		{"1001 ", false},
+		{"abc", false},
+		{"", false},
+	}
+	var ids, want []string
+	for _, tt := range tests {
+		ids = append(ids, tt.id)
+		if tt.valid {
+			want = append(want, tt.id)
+		}
+	}
+	if got := rejectNonPhotoIds("test", ids); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseIngestSkipsNonPhotoIds(t *testing.T) {
+	useDirs(t)
+	fname := filepath.Join(*ingestDir, "region.ndjson")
+	writeFile(t, fname, `{"id":"1001"}`, `{"id":"12345678@N00"}`, `{"id":"1003"}`, `{"id":"1234567@N21"}`)
+	if got := parseIngest(fname); !slices.Equal(got, []string{"1001", "1003"}) {
+		t.Errorf("got %v, want the NSID and group id skipped", got)
+	}
+}
+
+func TestParseIngestStrictRejectsNonPhotoIds(t *testing.T) {
+	useDirs(t)
+	fname := filepath.Join(*ingestDir, "region.ndjson")
+	writeFile(t, fname, `{"id":"1001"}`, `{"id":"12345678@N00"}`, `{"id":"1234567@N21"}`)
+	old := *strict
+	defer func() { *strict = old }()
+	*strict = true
+	expectFatal(t, `has 2 ids that aren't photo ids, such as "12345678@N00"`, func() { parseIngest(fname) })
+}
+
+func TestMaxRuntime(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	// Each photo takes at least two slow calls, so the run can't get
+	// through three in time.
+	httpClient = slowDoer{next: httpClient, latency: 50 * time.Millisecond}
+	old := *maxRuntime
+	defer func() { *maxRuntime = old }()
+	*maxRuntime = 100 * time.Millisecond
+
+	ctx, cancel := runContext()
+	defer cancel()
+	ids := []string{"1001", "1003", "1006"}
+	out, existing := openRegionOutput("region")
+	_, err := processRegion(ctx, "region", ids, out, existing)
+	out.Close()
+	if err != nil {
+		t.Fatalf("got error %v, want the run to stop cleanly", err)
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Fatalf("run context ended with %v, want -max-runtime to have passed", ctx.Err())
+	}
+
+	written := entryIds(readEntries(outputFile("region")))
+	failed := parseFailures(outPath("region.failed.ndjson"))
+	if len(failed) == 0 {
+		t.Error("no ids were recorded as failures, want those not got to")
+	}
+	if got := append(written, failed...); !slices.Equal(got, ids) {
+		t.Errorf("wrote %v and recorded failures %v, want each id in one or the other", written, failed)
+	}
+}
+
+func TestUnlocatedPhotos(t *testing.T) {
+	useFixtures(t)
+	// 1001 has coordinates and 1003 doesn't.
+	located, unlocated := true, false
+	tests := []struct {
+		name            string
+		require, keep   bool
+		id              string
+		wantSkip        SkipReason
+		wantHasLocation *bool
+	}{
+		{"default", false, false, "1003", NotSkipped, nil},
+		{"require-location", true, false, "1003", SkipNoLocation, nil},
+		{"require-location", true, false, "1001", NotSkipped, nil},
+		{"keep-unlocated", false, true, "1003", NotSkipped, &unlocated},
+		{"keep-unlocated", false, true, "1001", NotSkipped, &located},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.id, func(t *testing.T) {
+			oldRequire, oldKeep := *requireLocation, *keepUnlocated
+			defer func() { *requireLocation, *keepUnlocated = oldRequire, oldKeep }()
+			*requireLocation, *keepUnlocated = tt.require, tt.keep
+
+			entry, skip, err := createEntry(context.Background(), tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if skip != tt.wantSkip {
+				t.Fatalf("got skip %q, want %q", skip, tt.wantSkip)
+			}
+			if !reflect.DeepEqual(entry.HasLocation, tt.wantHasLocation) {
+				got, _ := json.Marshal(entry.HasLocation)
+				want, _ := json.Marshal(tt.wantHasLocation)
+				t.Errorf("hasLocation is %s, want %s", got, want)
+			}
+			if tt.id == "1003" && skip == NotSkipped && (entry.LatitudeF != nil || entry.Latitude != "") {
+				t.Errorf("got coordinates %q, want them empty", entry.Latitude)
+			}
+		})
+	}
+}
+
+func TestLocationFlagsAreExclusive(t *testing.T) {
+	oldRequire, oldKeep := *requireLocation, *keepUnlocated
+	defer func() { *requireLocation, *keepUnlocated = oldRequire, oldKeep }()
+	*requireLocation, *keepUnlocated = true, true
+	expectFatal(t, "-require-location and -keep-unlocated are mutually exclusive", checkLocationFlags)
+}
+
+func TestBuddyIconURL(t *testing.T) {
+	tests := []struct {
+		cdn          string
+		farm         int
+		server, nsid string
+		want         string
+	}{
+		{cdnFarm, 66, "65535", "12345678@N00", "https://farm66.staticflickr.com/65535/buddyicons/12345678@N00.jpg"},
+		{cdnLive, 66, "65535", "12345678@N00", "https://live.staticflickr.com/65535/buddyicons/12345678@N00.jpg"},
+		// Owners without an icon get Flickr's default whatever the host.
+		{cdnFarm, 0, "0", "87654321@N00", "https://www.flickr.com/images/buddyicon.gif"},
+		{cdnLive, 0, "0", "87654321@N00", "https://www.flickr.com/images/buddyicon.gif"},
+	}
+	old := *cdn
+	defer func() { *cdn = old }()
+	for _, tt := range tests {
+		*cdn = tt.cdn
+		if got := buddyIconURL(tt.farm, tt.server, tt.nsid); got != tt.want {
+			t.Errorf("-cdn %s: got %s, want %s", tt.cdn, got, tt.want)
+		}
+	}
+}
+
+func TestStaticURLHosts(t *testing.T) {
+	files := photoFiles{id: "1001", server: "65535", farm: 66, secret: "abc"}
+	tests := []struct {
+		cdn  string
+		want string
+	}{
+		{cdnFarm, "https://farm66.staticflickr.com/65535/1001_abc_z.jpg"},
+		{cdnLive, "https://live.staticflickr.com/65535/1001_abc_z.jpg"},
+	}
+	old := *cdn
+	defer func() { *cdn = old }()
+	for _, tt := range tests {
+		*cdn = tt.cdn
+		if got, ok := files.sourceURL("Medium 640"); !ok || got != tt.want {
+			t.Errorf("-cdn %s: got %s, %v, want %s", tt.cdn, got, ok, tt.want)
+		}
+	}
+}
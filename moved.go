@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+type MovedPhoto struct {
+	Id      string `json:"id"`
+	MovedTo string `json:"movedTo"`
+}
+
+// findMovedPhoto makes a best-effort guess at the new id of a photo that is no
+// longer found, by searching its owner's photostream for a photo with the same
+// title taken at the same time. It needs the entry from a previous run, since
+// a dead photo tells us nothing about its owner. It returns "" if there isn't
+// exactly one candidate.
+func findMovedPhoto(ctx context.Context, old Entry) (string, error) {
+	if old.OwnerNSID == "" || old.DateTaken == "" {
+		return "", nil
+	}
+
+	var resp struct {
+		Photos struct {
+			Photo []struct {
+				Id    string `json:"id"`
+				Title string `json:"title"`
+			} `json:"photo"`
+		} `json:"photos"`
+	}
+	err := callFlickr(ctx, "flickr.photos.search", &resp, map[string]string{
+		"user_id":        old.OwnerNSID,
+		"min_taken_date": old.DateTaken,
+		"max_taken_date": old.DateTaken,
+		"per_page":       "10",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var candidates []string
+	for _, photo := range resp.Photos.Photo {
+		if photo.Id != old.Id && photo.Title == old.Title {
+			candidates = append(candidates, photo.Id)
+		}
+	}
+	if len(candidates) != 1 {
+		log.Printf("Could not resolve where %s moved to (%d candidates)", old.Id, len(candidates))
+		return "", nil
+	}
+	return candidates[0], nil
+}
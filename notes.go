@@ -0,0 +1,30 @@
+package main
+
+// Note is a rectangle of a photo annotated with text, in pixels of the
+// 500px-wide Medium size that Flickr positions notes against.
+type Note struct {
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	W    int    `json:"w"`
+	H    int    `json:"h"`
+	Text string `json:"text"`
+}
+
+type flickrNote struct {
+	X       flexInt `json:"x"`
+	Y       flexInt `json:"y"`
+	W       flexInt `json:"w"`
+	H       flexInt `json:"h"`
+	Content string  `json:"_content"`
+}
+
+// notes converts getInfo's notes, giving an empty rather than nil slice for a
+// photo without any so that it is distinguishable from notes not being
+// requested.
+func notes(flickrNotes []flickrNote) []Note {
+	notes := make([]Note, 0, len(flickrNotes))
+	for _, n := range flickrNotes {
+		notes = append(notes, Note{X: int(n.X), Y: int(n.Y), W: int(n.W), H: int(n.H), Text: n.Content})
+	}
+	return notes
+}
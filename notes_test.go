@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNotes(t *testing.T) {
+	tests := []struct {
+		name      string
+		withNotes bool
+		id        string
+		want      []Note
+	}{
+		{"with notes", true, "1001", []Note{{X: 212, Y: 40, W: 60, H: 38, Text: "Helvellyn"}}},
+		{"photo without notes", true, "1003", []Note{}},
+		{"not requested", false, "1001", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFixtures(t)
+			old := *withNotes
+			defer func() { *withNotes = old }()
+			*withNotes = tt.withNotes
+
+			entry, _, err := createEntry(context.Background(), tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entry.Notes, tt.want) {
+				got, _ := json.Marshal(entry.Notes)
+				want, _ := json.Marshal(tt.want)
+				t.Errorf("got notes %s, want %s", got, want)
+			}
+		})
+	}
+}
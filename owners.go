@@ -0,0 +1,122 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ownerReport is what report-owners knows about one owner's photos.
+type ownerReport struct {
+	username  string
+	nsid      string
+	photos    map[string]bool // Ids, since an id may be in more than one region
+	licenses  map[string]int  // Photos by license name
+	sampleURL string
+}
+
+// runReportOwners writes a CSV of every owner with photos in the output, for
+// checking attribution obligations: their username and NSID, how many of
+// their photos we have, how those are licensed and one photo's page. It makes
+// no API calls.
+func runReportOwners(args []string) {
+	fs := flag.NewFlagSet("report-owners", flag.ExitOnError)
+	region := fs.String("region", "", "Only report on this region, instead of all of them")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		log.Fatal("Usage: report-owners [-region name]")
+	}
+	writeOwnerReport(os.Stdout, *region)
+}
+
+// writeOwnerReport writes the report-owners CSV for one region, or for all of
+// them if region is empty.
+func writeOwnerReport(out io.Writer, region string) {
+	regions := []string{region}
+	if region == "" {
+		regions = outputRegions(*outDir)
+	}
+
+	owners := make(map[string]*ownerReport)
+	for _, region := range regions {
+		entries := parseRegion(region)
+		// In id order, so that the sample photo is the same from run to run.
+		ids := make([]string, 0, len(entries))
+		for id := range entries {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+		for _, id := range ids {
+			entry := entries[id]
+			owner, ok := owners[entry.OwnerNSID]
+			if !ok {
+				owner = &ownerReport{
+					username:  entry.OwnerUsername,
+					nsid:      entry.OwnerNSID,
+					photos:    make(map[string]bool),
+					licenses:  make(map[string]int),
+					sampleURL: entry.Webpage,
+				}
+				owners[entry.OwnerNSID] = owner
+			}
+			if owner.photos[entry.Id] {
+				continue
+			}
+			owner.photos[entry.Id] = true
+			owner.licenses[licenseName(entry)]++
+		}
+	}
+
+	sorted := make([]*ownerReport, 0, len(owners))
+	for _, owner := range owners {
+		sorted = append(sorted, owner)
+	}
+	slices.SortFunc(sorted, func(a, b *ownerReport) int {
+		return cmp.Or(len(b.photos)-len(a.photos), strings.Compare(a.nsid, b.nsid))
+	})
+	w := csv.NewWriter(out)
+	w.Write([]string{"username", "nsid", "photos", "licenses", "sample_url"})
+	for _, owner := range sorted {
+		w.Write([]string{owner.username, owner.nsid, fmt.Sprint(len(owner.photos)), licenseMix(owner.licenses), owner.sampleURL})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Reported on %d owners", len(owners))
+}
+
+// licenseName returns the name of an entry's license, looking it up for
+// entries written before licenseName was stored.
+func licenseName(entry Entry) string {
+	if entry.LicenseName != "" {
+		return entry.LicenseName
+	}
+	if l, ok := licenses[entry.License]; ok {
+		return l.name
+	}
+	return "License " + entry.License
+}
+
+// licenseMix formats photo counts by license, most used first, such as
+// "Attribution License: 3; All Rights Reserved: 1".
+func licenseMix(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(counts[b]-counts[a], strings.Compare(a, b))
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %d", name, counts[name])
+	}
+	return strings.Join(parts, "; ")
+}
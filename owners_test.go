@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReportOwners(t *testing.T) {
+	useDirs(t)
+	hillwalker := func(id, license string) Entry {
+		return Entry{Id: id, OwnerNSID: "12345678@N00", OwnerUsername: "hillwalker", License: license,
+			Webpage: "https://www.flickr.com/photos/hillwalker/" + id + "/"}
+	}
+	fellrunner := Entry{Id: "1003", OwnerNSID: "87654321@N00", OwnerUsername: "fellrunner", License: "0", LicenseName: "All Rights Reserved",
+		Webpage: "https://www.flickr.com/photos/fellrunner/1003/"}
+	writeEntries(t, outputFile("alps"), hillwalker("1001", "4"), hillwalker("1006", "4"), hillwalker("1007", "9"), fellrunner)
+	// 1001 is in both regions, and a refetched entry supersedes the first.
+	refetched := hillwalker("1001", "4")
+	refetched.RetrievedAt = time.Now()
+	writeEntries(t, outputFile("lakes"), hillwalker("1001", "4"), refetched)
+
+	header := []string{"username", "nsid", "photos", "licenses", "sample_url"}
+	tests := []struct {
+		region string
+		want   [][]string
+	}{
+		{"", [][]string{
+			header,
+			{"hillwalker", "12345678@N00", "3", "Attribution License: 2; Public Domain Dedication (CC0): 1", "https://www.flickr.com/photos/hillwalker/1001/"},
+			{"fellrunner", "87654321@N00", "1", "All Rights Reserved: 1", "https://www.flickr.com/photos/fellrunner/1003/"},
+		}},
+		{"lakes", [][]string{
+			header,
+			{"hillwalker", "12345678@N00", "1", "Attribution License: 1", "https://www.flickr.com/photos/hillwalker/1001/"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run("region="+tt.region, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeOwnerReport(&buf, tt.region)
+			got, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// unknownPartition holds the entries of photos whose year taken is unknown.
+const unknownPartition = "unknown"
+
+// partitioned reports whether -partition-by splits each region's output into
+// a directory of files.
+func partitioned() bool {
+	return *partitionBy != ""
+}
+
+// entryPartition returns the partition an entry belongs in under -partition-by
+// year.
+func entryPartition(entry Entry) string {
+	taken, err := time.Parse(time.DateTime, entry.DateTaken)
+	if err != nil || taken.Year() <= 0 {
+		// Flickr gives 0000-00-00 00:00:00 for some photos without a date.
+		return unknownPartition
+	}
+	return strconv.Itoa(taken.Year())
+}
+
+func partitionFile(region, partition string) string {
+	return outPath(filepath.Join(region, partition+*outExt))
+}
+
+// regionFiles returns the output files holding a region's entries.
+func regionFiles(region string) []string {
+	if !partitioned() {
+		return []string{outputFile(region)}
+	}
+	fnames, err := filepath.Glob(partitionFile(region, "*"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return fnames
+}
+
+// parseRegion returns the newest entry for each id in a region's output. A
+// refetched photo whose date taken changed is written to a new partition, so
+// the entry in its old partition is superseded by retrievedAt rather than by
+// order.
+func parseRegion(region string) map[string]Entry {
+	if !partitioned() {
+		return parseExisting(outputFile(region))
+	}
+	entries := make(map[string]Entry)
+	for _, fname := range regionFiles(region) {
+		for id, entry := range parseExisting(fname) {
+			if prev, ok := entries[id]; !ok || !entry.RetrievedAt.Before(prev.RetrievedAt) {
+				entries[id] = entry
+			}
+		}
+	}
+	return entries
+}
+
+// openRegionOutput opens a region's output for appending, returning a writer
+// for it and the entries it already has, unless -index db is set. Closing the
+// writer closes the files.
+func openRegionOutput(region string) (*entryWriter, map[string]Entry) {
+	existing := make(map[string]Entry)
+	if !indexed() {
+		// With -index db, processRegion consults the index instead.
+		existing = parseRegion(region)
+	}
+	if !partitioned() {
+		f := openEntries(outputFile(region))
+		out := newOutputBuffer(f)
+		enc := json.NewEncoder(out)
+		return startEntryWriter(func(Entry) *json.Encoder { return enc }, out.flush, func() { f.Close() }), existing
+	}
+
+	files := make(map[string]*os.File)
+	buffers := make(map[string]*outputBuffer)
+	encoders := make(map[string]*json.Encoder)
+	encoderFor := func(entry Entry) *json.Encoder {
+		partition := entryPartition(entry)
+		if enc, ok := encoders[partition]; ok {
+			return enc
+		}
+		fname := partitionFile(region, partition)
+		if err := os.MkdirAll(filepath.Dir(fname), dirMode); err != nil {
+			log.Fatal(err)
+		}
+		f := openEntries(fname)
+		files[partition] = f
+		buffers[partition] = newOutputBuffer(f)
+		encoders[partition] = json.NewEncoder(buffers[partition])
+		return encoders[partition]
+	}
+	flushFiles := func() {
+		for _, b := range buffers {
+			b.flush()
+		}
+	}
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	return startEntryWriter(encoderFor, flushFiles, closeFiles), existing
+}
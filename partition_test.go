@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEntryPartition(t *testing.T) {
+	tests := []struct {
+		dateTaken string
+		want      string
+	}{
+		{"2023-07-21 06:12:40", "2023"},
+		{"1999-12-31 23:59:59", "1999"},
+		{"0000-00-00 00:00:00", unknownPartition},
+		{"", unknownPartition},
+		{"July 2023", unknownPartition},
+	}
+	for _, tt := range tests {
+		if got := entryPartition(Entry{DateTaken: tt.dateTaken}); got != tt.want {
+			t.Errorf("taken %q: got partition %q, want %q", tt.dateTaken, got, tt.want)
+		}
+	}
+}
+
+func TestPartitionedOutput(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	old := *partitionBy
+	defer func() { *partitionBy = old }()
+	*partitionBy = "year"
+
+	// 1003 is already in an older partition, which counts for dedup.
+	if err := os.Mkdir(outPath("region"), dirMode); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, partitionFile("region", "2019"), Entry{Id: "1003", DateTaken: "2019-06-01 10:00:00", RetrievedAt: time.Now().UTC()})
+
+	out, existing := openRegionOutput("region")
+	stats, err := processRegion(context.Background(), "region", []string{"1001", "1003"}, out, existing)
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Written != 1 {
+		t.Errorf("wrote %d entries, want only 1001", stats.Written)
+	}
+
+	tests := []struct {
+		partition string
+		want      []string
+	}{
+		{"2023", []string{"1001"}},
+		{"2019", []string{"1003"}},
+	}
+	for _, tt := range tests {
+		if got := entryIds(readEntries(partitionFile("region", tt.partition))); !slices.Equal(got, tt.want) {
+			t.Errorf("partition %s has %v, want %v", tt.partition, got, tt.want)
+		}
+	}
+	if fnames, _ := filepath.Glob(partitionFile("region", "*")); len(fnames) != len(tests) {
+		t.Errorf("partitions are %v, want %d", fnames, len(tests))
+	}
+	if _, err := os.Stat(outputFile("region")); !os.IsNotExist(err) {
+		t.Errorf("wrote %s, want only partitions", outputFile("region"))
+	}
+}
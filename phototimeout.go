@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// photoClock times a photo against -photo-timeout from when its first request
+// is sent, rather than from when hydrating it began, so that a photo queued
+// behind -prefetch's others for the rate limiter isn't charged for the wait.
+type photoClock struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	stopped bool
+}
+
+type photoClockKey struct{}
+
+// withPhotoTimeout returns a context that is cancelled, with
+// context.DeadlineExceeded as its cause, timeout after startPhotoClock is
+// first called with it.
+func withPhotoTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	clock := &photoClock{timeout: timeout, cancel: cancel}
+	return context.WithValue(ctx, photoClockKey{}, clock), func() {
+		clock.stop()
+		cancel(context.Canceled)
+	}
+}
+
+// startPhotoClock starts the -photo-timeout clock of the photo ctx is for, if
+// there is one and it hasn't started yet.
+func startPhotoClock(ctx context.Context) {
+	clock, ok := ctx.Value(photoClockKey{}).(*photoClock)
+	if !ok {
+		return
+	}
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	if clock.timer != nil || clock.stopped {
+		return
+	}
+	clock.timer = time.AfterFunc(clock.timeout, func() { clock.cancel(context.DeadlineExceeded) })
+}
+
+func (c *photoClock) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+// timedOut reports whether the photo ctx is for ran out of -photo-timeout.
+func timedOut(ctx context.Context) bool {
+	return context.Cause(ctx) == context.DeadlineExceeded
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPhotoTimeoutStartsAtFirstRequest(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	ctx, cancel := withPhotoTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Time spent before the first request, such as waiting on the rate
+	// limiter behind prefetched photos, doesn't count.
+	time.Sleep(2 * timeout)
+	if ctx.Err() != nil {
+		t.Fatalf("timed out before the first request: %s", context.Cause(ctx))
+	}
+
+	startPhotoClock(ctx)
+	time.Sleep(timeout / 2)
+	// Later requests don't restart the clock.
+	startPhotoClock(ctx)
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * timeout):
+		t.Fatal("didn't time out after the first request")
+	}
+	if !timedOut(ctx) {
+		t.Errorf("cause is %v, want context.DeadlineExceeded", context.Cause(ctx))
+	}
+}
+
+func TestPhotoTimeoutCancel(t *testing.T) {
+	ctx, cancel := withPhotoTimeout(context.Background(), time.Millisecond)
+	startPhotoClock(ctx)
+	cancel()
+	time.Sleep(5 * time.Millisecond)
+	if timedOut(ctx) {
+		t.Error("a photo finished with before its timeout counts as timed out")
+	}
+}
+
+// blockingDoer never answers requests about photo, as if Flickr hung on it,
+// and passes the rest on to next.
+type blockingDoer struct {
+	next  Doer
+	photo string
+}
+
+func (d blockingDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.URL.Query().Get("photo_id") == d.photo {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	return d.next.Do(req)
+}
+
+func TestPhotoTimeoutFailsSlowPhoto(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	httpClient = blockingDoer{next: httpClient, photo: "1001"}
+	old := *photoTimeout
+	defer func() { *photoTimeout = old }()
+	*photoTimeout = 20 * time.Millisecond
+
+	var buf bytes.Buffer
+	out := newEntryWriter(&buf)
+	stats, err := processRegion(context.Background(), "region", []string{"1001", "1003"}, out, make(map[string]Entry))
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Failed != 1 || stats.Written != 1 {
+		t.Errorf("%d failed and %d written, want the slow photo to fail and the next written", stats.Failed, stats.Written)
+	}
+	if got := entryIds(decodeEntries(buf.Bytes())); !slices.Equal(got, []string{"1003"}) {
+		t.Errorf("wrote %v, want [1003]", got)
+	}
+	data, err := os.ReadFile(outPath("region.failed.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failure Failure
+	if err := json.Unmarshal(data, &failure); err != nil {
+		t.Fatal(err)
+	}
+	if failure != (Failure{Id: "1001", Reason: "photo-timeout"}) {
+		t.Errorf("recorded failure %+v, want 1001 as photo-timeout", failure)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import "strings"
+
+// allowedWoeids and deniedWoeids are set from -allow-woeid and -deny-woeid.
+// Nil means no filtering.
+var allowedWoeids, deniedWoeids map[string]bool
+
+func parseWoeids(s string) map[string]bool {
+	if s == "" {
+		return nil
+	}
+	woeids := make(map[string]bool)
+	for _, woeid := range strings.Split(s, ",") {
+		woeids[strings.TrimSpace(woeid)] = true
+	}
+	return woeids
+}
+
+// locationWoeids returns the WOEID of a location followed by those of the
+// places containing it, as given by getInfo.
+func locationWoeids(location flickrLocation) []string {
+	var woeids []string
+	for _, woeid := range []flexString{
+		location.Woeid, location.Neighborhood.Woeid, location.Locality.Woeid,
+		location.County.Woeid, location.Region.Woeid, location.Country.Woeid,
+	} {
+		if woeid != "" {
+			woeids = append(woeids, string(woeid))
+		}
+	}
+	return woeids
+}
+
+// woeidAllowed reports whether a photo passes -allow-woeid and -deny-woeid. A
+// WOEID in either list matches the place itself or anywhere inside it, and
+// denying takes precedence. Photos without a location never match the allow
+// list.
+func woeidAllowed(location flickrLocation) bool {
+	if allowedWoeids == nil && deniedWoeids == nil {
+		return true
+	}
+	allowed := allowedWoeids == nil
+	for _, woeid := range locationWoeids(location) {
+		if deniedWoeids[woeid] {
+			return false
+		}
+		if allowedWoeids[woeid] {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// Places is the place hierarchy a photo is in, from most to least
+// specific. Levels Flickr doesn't know are nil.
+type Places struct {
+	Neighborhood *Place `json:"neighborhood,omitempty"`
+	Locality     *Place `json:"locality,omitempty"`
+	County       *Place `json:"county,omitempty"`
+	Region       *Place `json:"region,omitempty"`
+	Country      *Place `json:"country,omitempty"`
+}
+
+type Place struct {
+	Name    string `json:"name"`
+	PlaceId string `json:"placeId,omitempty"`
+	Woeid   string `json:"woeid,omitempty"`
+}
+
+func (p flickrPlace) part() *Place {
+	if p.Content == "" {
+		return nil
+	}
+	return &Place{Name: p.Content, PlaceId: p.PlaceId, Woeid: string(p.Woeid)}
+}
+
+// locationParts returns the structured hierarchy of a location, or nil if it
+// has no named places.
+func locationParts(location flickrLocation) *Places {
+	parts := Places{
+		Neighborhood: location.Neighborhood.part(),
+		Locality:     location.Locality.part(),
+		County:       location.County.part(),
+		Region:       location.Region.part(),
+		Country:      location.Country.part(),
+	}
+	if parts == (Places{}) {
+		return nil
+	}
+	return &parts
+}
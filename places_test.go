@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLocationParts(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		want     *Places
+	}{
+		{
+			"full hierarchy",
+			`{"woeid":"12345","neighborhood":{"_content":"Seathwaite","place_id":"n1","woeid":"11111"},
+			"locality":{"_content":"Borrowdale","place_id":"l1","woeid":22222},
+			"county":{"_content":"Cumbria","place_id":"c1","woeid":"12602133"},
+			"region":{"_content":"England","place_id":"r1","woeid":"24554868"},
+			"country":{"_content":"United Kingdom","place_id":"k1","woeid":"23424975"}}`,
+			&Places{
+				Neighborhood: &Place{Name: "Seathwaite", PlaceId: "n1", Woeid: "11111"},
+				Locality:     &Place{Name: "Borrowdale", PlaceId: "l1", Woeid: "22222"},
+				County:       &Place{Name: "Cumbria", PlaceId: "c1", Woeid: "12602133"},
+				Region:       &Place{Name: "England", PlaceId: "r1", Woeid: "24554868"},
+				Country:      &Place{Name: "United Kingdom", PlaceId: "k1", Woeid: "23424975"},
+			},
+		},
+		{
+			"partial",
+			`{"locality":{"_content":"Keswick"},"neighborhood":{"_content":""},
+			"country":{"_content":"United Kingdom","woeid":"23424975"}}`,
+			&Places{
+				Locality: &Place{Name: "Keswick"},
+				Country:  &Place{Name: "United Kingdom", Woeid: "23424975"},
+			},
+		},
+		{"no places", `{"latitude":"54.5","longitude":"-3.2"}`, nil},
+		{"no location", `{}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var location flickrLocation
+			if err := json.Unmarshal([]byte(tt.location), &location); err != nil {
+				t.Fatal(err)
+			}
+			got := locationParts(location)
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("got %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
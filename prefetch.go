@@ -0,0 +1,72 @@
+package main
+
+import "context"
+
+// A prefetcher hydrates the photos a region needs ahead of processRegion, so
+// that one photo's calls are waiting on the rate limiter while the previous
+// photo's responses are still in flight. The limiter still spaces out every
+// request; prefetching only stops request latency adding to the wait.
+type prefetcher struct {
+	ctx     context.Context
+	region  string
+	futures chan *hydration
+	head    *hydration // Received but not yet taken
+}
+
+type hydration struct {
+	index int
+	done  chan struct{}
+	entry Entry
+	skip  SkipReason
+	err   error
+}
+
+// startPrefetch starts hydrating the ids at the indices in want, in order,
+// keeping up to ahead photos hydrated or in flight beyond the one being
+// taken. Cancelling ctx stops it.
+func startPrefetch(ctx context.Context, region string, ids []string, want []int, ahead int) *prefetcher {
+	p := &prefetcher{ctx: ctx, region: region, futures: make(chan *hydration, ahead)}
+	go func() {
+		defer close(p.futures)
+		for _, i := range want {
+			h := &hydration{index: i, done: make(chan struct{})}
+			select {
+			case p.futures <- h:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				defer close(h.done)
+				h.entry, h.skip, h.err = hydrate(ctx, region, ids[i])
+			}()
+		}
+	}()
+	return p
+}
+
+// take returns the hydration of ids[i], waiting for it if it was prefetched
+// and hydrating it now if it wasn't.
+func (p *prefetcher) take(i int, id string) (Entry, SkipReason, error) {
+	for {
+		if p.head == nil {
+			h, ok := <-p.futures
+			if !ok {
+				break
+			}
+			p.head = h
+		}
+		if p.head.index >= i {
+			break
+		}
+		// processRegion passed over an id after it was prefetched, such as
+		// a repeat of an id it has just written.
+		p.head = nil
+	}
+	if p.head == nil || p.head.index != i {
+		return hydrate(p.ctx, p.region, id)
+	}
+	h := p.head
+	p.head = nil
+	<-h.done
+	return h.entry, h.skip, h.err
+}
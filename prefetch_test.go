@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// slowDoer answers every photo with the fixtures for 1001 after latency, like
+// a Flickr that is far away.
+type slowDoer struct {
+	next    Doer
+	latency time.Duration
+}
+
+func (d slowDoer) Do(req *http.Request) (*http.Response, error) {
+	time.Sleep(d.latency)
+	req = req.Clone(req.Context())
+	query := req.URL.Query()
+	if query.Has("photo_id") {
+		query.Set("photo_id", "1001")
+		req.URL.RawQuery = query.Encode()
+	}
+	return d.next.Do(req)
+}
+
+// BenchmarkPrefetch hydrates a region with requests spaced by interval and
+// answered after latency, with and without -prefetch.
+func BenchmarkPrefetch(b *testing.B) {
+	const interval = 10 * time.Millisecond
+	for _, latency := range []time.Duration{interval / 2, interval, 2 * interval} {
+		for _, ahead := range []int{0, 4} {
+			b.Run(fmt.Sprintf("latency=%s/prefetch=%d", latency, ahead), func(b *testing.B) {
+				useFixtures(b)
+				httpClient = slowDoer{next: httpClient, latency: latency}
+				requestInterval = interval
+				oldOut, oldAhead := *outDir, *prefetchAhead
+				defer func() { *outDir, *prefetchAhead = oldOut, oldAhead }()
+				*outDir = b.TempDir()
+				*prefetchAhead = ahead
+
+				ids := make([]string, 20)
+				for i := range ids {
+					ids[i] = fmt.Sprint(2000 + i)
+				}
+				b.ResetTimer()
+				for range b.N {
+					var buf bytes.Buffer
+					out := newEntryWriter(&buf)
+					stats, err := processRegion(context.Background(), "bench", ids, out, make(map[string]Entry))
+					out.Close()
+					if err != nil {
+						b.Fatal(err)
+					}
+					if stats.Written != len(ids) {
+						b.Fatalf("wrote %d entries, want %d", stats.Written, len(ids))
+					}
+				}
+				b.ReportMetric(float64(b.Elapsed().Milliseconds())/float64(b.N), "ms/region")
+			})
+		}
+	}
+}
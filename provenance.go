@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provenance records where an entry's data came from, which helps explain why
+// a field is missing (the method that fills it wasn't called).
+type Provenance struct {
+	Methods   []string  `json:"methods"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	KeyHash   string    `json:"keyHash"` // Prefixes of the SHA-256 of each API key used, comma-separated
+}
+
+type provenanceRecorder struct {
+	mu      sync.Mutex
+	methods []string
+	keys    []string
+}
+
+type provenanceKey struct{}
+
+// withProvenance returns a context in which callFlickr records each method it
+// calls to the returned recorder.
+func withProvenance(ctx context.Context) (context.Context, *provenanceRecorder) {
+	rec := &provenanceRecorder{}
+	return context.WithValue(ctx, provenanceKey{}, rec), rec
+}
+
+func recordMethod(ctx context.Context, method string, key *apiKey) {
+	rec, ok := ctx.Value(provenanceKey{}).(*provenanceRecorder)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if !slices.Contains(rec.methods, method) {
+		rec.methods = append(rec.methods, method)
+	}
+	if !slices.Contains(rec.keys, key.hash) {
+		rec.keys = append(rec.keys, key.hash)
+	}
+}
+
+func (rec *provenanceRecorder) provenance() *Provenance {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return &Provenance{
+		Methods:   slices.Clone(rec.methods),
+		FetchedAt: time.Now().UTC(),
+		KeyHash:   strings.Join(rec.keys, ","),
+	}
+}
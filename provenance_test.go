@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// methodsDoer records the methods called through it.
+type methodsDoer struct {
+	next    Doer
+	mu      sync.Mutex
+	methods []string
+}
+
+func (d *methodsDoer) Do(req *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	d.methods = append(d.methods, req.URL.Query().Get("method"))
+	d.mu.Unlock()
+	return d.next.Do(req)
+}
+
+func TestProvenanceListsMethodsCalled(t *testing.T) {
+	tests := []struct {
+		name        string
+		enrichments []string
+	}{
+		{"base", nil},
+		{"enriched", []string{"exif", "favorites"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFixtures(t)
+			doer := &methodsDoer{next: httpClient}
+			httpClient = doer
+			old := enabledEnrichments
+			defer func() { enabledEnrichments = old }()
+			enabledEnrichments = tt.enrichments
+
+			entry, _, err := createEntry(context.Background(), "1001")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Provenance == nil {
+				t.Fatal("entry has no provenance")
+			}
+			if !slices.Equal(entry.Provenance.Methods, doer.methods) {
+				t.Errorf("provenance lists %v, want the methods called, %v", entry.Provenance.Methods, doer.methods)
+			}
+			if want := newAPIKey("test").hash; entry.Provenance.KeyHash != want {
+				t.Errorf("key hash is %q, want %q", entry.Provenance.KeyHash, want)
+			}
+		})
+	}
+}
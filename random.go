@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// rng is the source for all randomized behavior, so that -seed makes a run
+// reproducible. It is safe for concurrent use.
+var rng = &lockedRand{r: rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), 0))}
+
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func seedRand(seed uint64) {
+	log.Printf("Seeding randomness with %d", seed)
+	rng = &lockedRand{r: rand.New(rand.NewPCG(seed, 0))}
+}
+
+// Jitter returns d scaled by a random factor between 0.5 and 1.5, so that
+// retries from many photos don't line up.
+func (l *lockedRand) Jitter(d time.Duration) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Duration(float64(d) * (0.5 + l.r.Float64()))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// jitters returns the next n jitters of d.
+func jitters(n int, d time.Duration) []time.Duration {
+	var got []time.Duration
+	for range n {
+		got = append(got, rng.Jitter(d))
+	}
+	return got
+}
+
+func TestSeedRand(t *testing.T) {
+	old := rng
+	defer func() { rng = old }()
+
+	tests := []struct {
+		a, b  uint64
+		equal bool
+	}{
+		{1, 1, true},
+		{42, 42, true},
+		{1, 2, false},
+	}
+	for _, tt := range tests {
+		seedRand(tt.a)
+		first := jitters(10, time.Second)
+		seedRand(tt.b)
+		second := jitters(10, time.Second)
+		if slices.Equal(first, second) != tt.equal {
+			t.Errorf("seeds %d and %d give jitters %v and %v, want them equal: %v", tt.a, tt.b, first, second, tt.equal)
+		}
+	}
+}
+
+func TestJitterRange(t *testing.T) {
+	old := rng
+	defer func() { rng = old }()
+	seedRand(1)
+	for _, d := range jitters(1000, time.Second) {
+		if d < time.Second/2 || d >= 3*time.Second/2 {
+			t.Fatalf("jittered 1s to %s, want between 0.5s and 1.5s", d)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// rawDir is where -save-raw keeps API responses, named by responseName.
+func rawDir() string {
+	return outPath("raw")
+}
+
+// saveRawResponse keeps the body of a successful API response for replay.
+// A later response to the same call replaces it.
+func saveRawResponse(query url.Values, body []byte) {
+	if err := os.MkdirAll(rawDir(), dirMode); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(rawDir(), responseName(query)), body, fileMode); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// rawFetchedAt returns when the getInfo response for a photo was saved.
+func rawFetchedAt(id string) time.Time {
+	query := url.Values{"method": {"flickr.photos.getInfo"}}
+	for k, v := range localized(map[string]string{"photo_id": id}) {
+		query.Set(k, v)
+	}
+	info, err := os.Stat(filepath.Join(rawDir(), responseName(query)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return info.ModTime().UTC()
+}
+
+// rawCacheDoer answers API requests from the -save-raw responses in files,
+// failing those it has no response for.
+type rawCacheDoer struct {
+	files fs.FS
+}
+
+func (d rawCacheDoer) Do(req *http.Request) (*http.Response, error) {
+	query := req.URL.Query()
+	body, err := fs.ReadFile(d.files, responseName(query))
+	if err != nil {
+		return nil, fmt.Errorf("%s for %s isn't in %s, so needs a live fetch", query.Get("method"), query.Get("photo_id"), rawDir())
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// runReplay rebuilds a region's output from the responses -save-raw kept,
+// without calling Flickr, so that a change to how entries are made can be
+// applied to existing output. Ids without every response they need are
+// recorded in out/<region>.replay-failed.ndjson, as needing a live fetch.
+// The run's other side files are left in a scratch directory, webhooks aren't
+// sent for entries that aren't new and -checksum-images is off, since it
+// needs the images themselves. Any id that isn't rebuilt
+// keeps its existing entry, including those that aren't in the ingest file.
+// Rebuilt entries keep the retrievedAt of the entry they replace, or else
+// take when their response was saved.
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	region := flags.String("region", "", "Region to rebuild the output of")
+	flags.Parse(args)
+	if *region == "" || flags.NArg() != 0 {
+		log.Fatal("Usage: replay -region name")
+	}
+	if partitioned() {
+		log.Fatal("replay does not support -partition-by")
+	}
+
+	httpClient = rawCacheDoer{files: os.DirFS(rawDir())}
+	keys.keys = []*apiKey{newAPIKey("replay")}
+	requestInterval = 0
+	*hourlyCap = 0
+	*saveRaw = false
+	*checksumImages = false
+	hook.Close()
+	hook = nil
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	if *liveLicenses {
+		loadLicenses(ctx)
+	}
+
+	fname := outputFile(*region)
+	oldEntries := readEntries(fname)
+	old := parseExisting(fname)
+	ids := dedupIds(parseRegionIngest(*region))
+
+	realOutDir := *outDir
+	scratch, err := os.MkdirTemp("", "hydrator-replay")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(scratch)
+	*outDir = scratch
+	var buf bytes.Buffer
+	out := newEntryWriter(&buf)
+	stats, err := processRegion(ctx, *region, ids, out, make(map[string]Entry))
+	out.Close()
+	*outDir = realOutDir
+	if err != nil {
+		log.Fatal(err)
+	}
+	replayFailures(scratch, *region)
+	if ctx.Err() != nil {
+		log.Fatal("Interrupted, so leaving the output as it was")
+	}
+
+	replayed := make(map[string]Entry)
+	for _, entry := range decodeEntries(buf.Bytes()) {
+		// The data is as old as the responses it was rebuilt from, which
+		// -refresh-older-than must still see.
+		if existing, ok := old[entry.Id]; ok {
+			copyFields(&entry, existing, preservedFields)
+			entry.RetrievedAt = existing.RetrievedAt
+			entry.ImageHash = existing.ImageHash
+		} else {
+			entry.RetrievedAt = rawFetchedAt(entry.Id)
+		}
+		if entry.Provenance != nil {
+			entry.Provenance.FetchedAt = entry.RetrievedAt
+		}
+		entry.ContentHash = contentHash(entry)
+		replayed[entry.Id] = entry
+	}
+	var entries []Entry
+	var kept int
+	handled := make(map[string]bool)
+	for _, id := range ids {
+		handled[id] = true
+		if entry, ok := replayed[id]; ok {
+			entries = append(entries, entry)
+		} else if entry, ok := old[id]; ok {
+			entries = append(entries, entry)
+			kept++
+		}
+	}
+	// Entries from -stdin or -search aren't in the ingest file, so weren't
+	// replayed, but are kept all the same.
+	for _, entry := range oldEntries {
+		if !handled[entry.Id] {
+			handled[entry.Id] = true
+			entries = append(entries, old[entry.Id])
+			kept++
+		}
+	}
+	rewriteOutput(fname, entries)
+	log.Printf("Replayed %s: %d entries rebuilt, %d ids need a live fetch, %d ids not rebuilt kept their existing entry",
+		fname, len(replayed), stats.Failed, kept)
+}
+
+// replayFailures moves the failures a replay of region recorded in scratch to
+// out/<region>.replay-failed.ndjson, replacing the last replay's.
+func replayFailures(scratch, region string) {
+	fname := outPath(region + ".replay-failed.ndjson")
+	data, err := os.ReadFile(filepath.Join(scratch, region+".failed.ndjson"))
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.Remove(fname); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(fname, data, fileMode); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	oldCap, oldChecksum, oldHook := *hourlyCap, *checksumImages, hook
+	defer func() { *hourlyCap, *checksumImages, hook = oldCap, oldChecksum, oldHook }()
+
+	// The raw responses are named as the selftest fixtures are.
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "selftest", "responses", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(rawDir(), dirMode); err != nil {
+		t.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(rawDir(), filepath.Base(fixture)), data, fileMode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(*ingestDir, "region.ndjson"), `{"id":"1001"}`, `{"id":"9999"}`)
+	retrievedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeEntries(t, outputFile("region"),
+		Entry{Id: "1001", Title: "Before the schema change", RetrievedAt: retrievedAt, ImageHash: "ffff"},
+		Entry{Id: "7777", Title: "From -search"})
+
+	var sent atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { sent.Add(1) }))
+	defer server.Close()
+	hook = newWebhook(server.URL)
+	*checksumImages = true
+
+	runReplay([]string{"-region", "region"})
+
+	entries := readEntries(outputFile("region"))
+	if got := entryIds(entries); !slices.Equal(got, []string{"1001", "7777"}) {
+		t.Fatalf("output has %v, want 1001 rebuilt and 7777 kept", got)
+	}
+	if entries[0].Title == "Before the schema change" {
+		t.Error("1001 wasn't rebuilt from its raw responses")
+	}
+	if !entries[0].RetrievedAt.Equal(retrievedAt) || entries[0].ImageHash != "ffff" {
+		t.Errorf("1001 has retrievedAt %s and imageHash %q, want those of the entry it replaced", entries[0].RetrievedAt, entries[0].ImageHash)
+	}
+	if got := parseFailures(outPath("region.replay-failed.ndjson")); !slices.Equal(got, []string{"9999"}) {
+		t.Errorf("replay failures are %v, want 9999 as needing a live fetch", got)
+	}
+	for _, name := range []string{"region.failed.ndjson", "region.skipped.ndjson", "region.duplicates.json"} {
+		if _, err := os.Stat(outPath(name)); !os.IsNotExist(err) {
+			t.Errorf("replay wrote %s, want the region's side files left alone", name)
+		}
+	}
+	if n := sent.Load(); n != 0 {
+		t.Errorf("replay sent %d webhooks, want none", n)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// retryBackoff is the typical wait before the first retry of a call,
+// doubling for each retry after it. Each wait is jittered.
+const retryBackoff = 2 * time.Second
+
+// retriesUsed counts retries made by the whole run, which -retry-budget caps
+// so that a persistently flaky Flickr can't keep a run going indefinitely.
+var retriesUsed atomic.Int64
+
+var budgetExhausted sync.Once
+
+// withRetries makes call, retrying transient failures up to -retries times
+// while the run's retry budget lasts.
+func withRetries(ctx context.Context, method string, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := call()
+		requests.Observe(err)
+		if err == nil || ctx.Err() != nil || !isTransient(err) || attempt >= *retries {
+			return err
+		}
+		if !takeRetry() {
+			budgetExhausted.Do(func() {
+				log.Printf("Used the retry budget of %d, so failing calls are no longer retried", *retryBudget)
+			})
+			return err
+		}
+
+		delay := rng.Jitter(retryBackoff << attempt).Round(time.Millisecond)
+		log.Printf("Retrying %s in %s: %s", method, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// takeRetry reserves one retry from -retry-budget, reporting false once it is
+// spent.
+func takeRetry() bool {
+	n := retriesUsed.Add(1)
+	if *retryBudget > 0 && n > int64(*retryBudget) {
+		retriesUsed.Add(-1)
+		return false
+	}
+	return true
+}
+
+// HTTPStatusError is a non-200 response from the Flickr API.
+type HTTPStatusError struct {
+	Method     string
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s: HTTP status %d", e.Method, e.StatusCode)
+}
+
+// isTransient reports whether a failed call might succeed if made again:
+// connection problems, rate limiting and server errors. Errors Flickr
+// reports in a successful response are about the request, so aren't retried.
+func isTransient(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
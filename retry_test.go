@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// unavailableDoer answers every request with 503 Service Unavailable,
+// counting the calls made.
+type unavailableDoer struct {
+	calls atomic.Int64
+}
+
+func (d *unavailableDoer) Do(req *http.Request) (*http.Response, error) {
+	d.calls.Add(1)
+	return &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// useRetryBudget sets -retries and -retry-budget, and how much of the budget
+// is already used, for the rest of the test.
+func useRetryBudget(t *testing.T, retryCount, budget int, used int64) {
+	t.Helper()
+	oldRetries, oldBudget, oldUsed := *retries, *retryBudget, retriesUsed.Load()
+	t.Cleanup(func() {
+		*retries, *retryBudget = oldRetries, oldBudget
+		retriesUsed.Store(oldUsed)
+	})
+	*retries, *retryBudget = retryCount, budget
+	retriesUsed.Store(used)
+}
+
+func TestTakeRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		budget   int
+		used     int64
+		want     bool
+		wantUsed int64
+	}{
+		{"no budget", 0, 10000, true, 10001},
+		{"within the budget", 5, 3, true, 4},
+		{"last retry of the budget", 5, 4, true, 5},
+		{"budget spent", 5, 5, false, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRetryBudget(t, 2, tt.budget, tt.used)
+			if got := takeRetry(); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if got := retriesUsed.Load(); got != tt.wantUsed {
+				t.Errorf("%d retries used, want %d", got, tt.wantUsed)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetExhausted(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	useRetryBudget(t, 3, 2, 2)
+	doer := &unavailableDoer{}
+	httpClient = doer
+
+	// With the budget spent, each photo fails on its first call rather than
+	// waiting out -retries backoffs.
+	var buf bytes.Buffer
+	out := newEntryWriter(&buf)
+	stats, err := processRegion(context.Background(), "region", []string{"1001", "1003"}, out, make(map[string]Entry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	if stats.Failed != 2 {
+		t.Errorf("%d photos failed, want 2", stats.Failed)
+	}
+	if calls := doer.calls.Load(); calls != 2 {
+		t.Errorf("made %d calls, want one per photo with no retries", calls)
+	}
+	if used := retriesUsed.Load(); used != 2 {
+		t.Errorf("%d retries used, want the budget of 2", used)
+	}
+
+	data, err := os.ReadFile(outPath("region.failed.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed []string
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var failure Failure
+		if err := dec.Decode(&failure); err != nil {
+			t.Fatal(err)
+		}
+		failed = append(failed, failure.Id)
+	}
+	if strings.Join(failed, ",") != "1001,1003" {
+		t.Errorf("recorded failures for %v, want 1001 and 1003", failed)
+	}
+}
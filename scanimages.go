@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+type DeadImage struct {
+	Id     string `json:"id"`
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// runScanImages checks that the image each entry in the output links to still
+// exists, recording those that don't in out/<region>.dead-images.ndjson and,
+// with -prune, removing their entries. It makes no API calls, only HEAD
+// requests to the static servers, paced like -checksum-images downloads.
+func runScanImages(args []string) {
+	fs := flag.NewFlagSet("scan-images", flag.ExitOnError)
+	prune := fs.Bool("prune", false, "Remove entries whose image is gone from the output")
+	fs.Parse(args)
+
+	regions := fs.Args()
+	if len(regions) == 0 {
+		regions = outputRegions(*outDir)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	for _, region := range regions {
+		dead := scanRegionImages(ctx, region)
+		if ctx.Err() != nil {
+			log.Print("Interrupted, so not pruning")
+			return
+		}
+		if *prune && len(dead) > 0 {
+			for _, fname := range regionFiles(region) {
+				removeBlocked(fname, dead)
+			}
+		}
+	}
+}
+
+// scanRegionImages checks the images of a region's entries, returning the ids
+// of those that are gone.
+func scanRegionImages(ctx context.Context, region string) map[string]bool {
+	reportFname := outPath(region + ".dead-images.ndjson")
+	// The report is of the output as it is now, so replaces any earlier one.
+	if err := os.Remove(reportFname); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+	report := &lazyEncoder{fname: reportFname}
+	defer report.Close()
+
+	entries := parseRegion(region)
+	ids := make([]string, 0, len(entries))
+	for id := range entries {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	dead := make(map[string]bool)
+	var checked int
+	for _, id := range ids {
+		url := imageURL(entries[id])
+		if url == "" {
+			continue
+		}
+		status, err := headImage(ctx, url)
+		if ctx.Err() != nil {
+			return dead
+		}
+		if err != nil {
+			log.Printf("Failed to check the image of %s: %s", id, err)
+			continue
+		}
+		checked++
+		if status == http.StatusNotFound || status == http.StatusGone {
+			log.Printf("Image of %s is gone: %s", id, url)
+			report.Encode(DeadImage{Id: id, URL: url, Status: status})
+			dead[id] = true
+		}
+	}
+	log.Printf("Checked %d images in %s, %d are gone", checked, region, len(dead))
+	return dead
+}
+
+// imageURL returns the image an entry is displayed with: its -display-strategy
+// choice, or else its largest size.
+func imageURL(entry Entry) string {
+	if entry.DisplayURL != "" {
+		return entry.DisplayURL
+	}
+	largest, _ := largestSize(entry.Sizes)
+	return largest.Source
+}
+
+// headImage returns the status of a HEAD request for an image. Flickr
+// redirects requests for a deleted photo's files to a placeholder rather than
+// failing them, so that counts as not found.
+func headImage(ctx context.Context, url string) (int, error) {
+	if err := downloads.Wait(ctx, 0); err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.Request != nil && strings.Contains(resp.Request.URL.Path, "photo_unavailable") {
+		return http.StatusNotFound, nil
+	}
+	return resp.StatusCode, nil
+}
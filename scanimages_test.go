@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestScanImages(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone.jpg":
+			http.NotFound(w, r)
+		case "/removed.jpg":
+			w.WriteHeader(http.StatusGone)
+		case "/deleted.jpg":
+			// As Flickr does for the files of a deleted photo.
+			http.Redirect(w, r, "/images/photo_unavailable.png", http.StatusFound)
+		case "/broken.jpg":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	httpClient = server.Client()
+
+	tests := []struct {
+		entry Entry
+		dead  bool
+	}{
+		{Entry{Id: "1", DisplayURL: server.URL + "/ok.jpg"}, false},
+		{Entry{Id: "2", DisplayURL: server.URL + "/gone.jpg"}, true},
+		{Entry{Id: "3", DisplayURL: server.URL + "/removed.jpg"}, true},
+		{Entry{Id: "4", DisplayURL: server.URL + "/deleted.jpg"}, true},
+		// A server error might not last, so isn't taken as the image being gone.
+		{Entry{Id: "5", DisplayURL: server.URL + "/broken.jpg"}, false},
+		// Without a display URL, the largest size is checked.
+		{Entry{Id: "6", Sizes: []PictureSize{
+			{Width: 100, Height: 75, Source: server.URL + "/ok.jpg"},
+			{Width: 1024, Height: 768, Source: server.URL + "/gone.jpg"},
+		}}, true},
+		{Entry{Id: "7"}, false},
+	}
+	var entries []Entry
+	var wantDead, wantKept []string
+	for _, tt := range tests {
+		entries = append(entries, tt.entry)
+		if tt.dead {
+			wantDead = append(wantDead, tt.entry.Id)
+		} else {
+			wantKept = append(wantKept, tt.entry.Id)
+		}
+	}
+	writeEntries(t, outputFile("region"), entries...)
+
+	runScanImages([]string{"-prune", "region"})
+
+	data, err := os.ReadFile(outPath("region.dead-images.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dead []string
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var image DeadImage
+		if err := dec.Decode(&image); err != nil {
+			t.Fatal(err)
+		}
+		dead = append(dead, image.Id)
+	}
+	if !slices.Equal(dead, wantDead) {
+		t.Errorf("reported %v as dead, want %v", dead, wantDead)
+	}
+	if got := entryIds(readEntries(outputFile("region"))); !slices.Equal(got, wantKept) {
+		t.Errorf("pruned output has %v, want %v", got, wantKept)
+	}
+}
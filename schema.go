@@ -0,0 +1,224 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"sort"
+)
+
+// builtinSchema describes the entries this version writes. It must be updated
+// alongside Entry, since it rejects fields it doesn't list.
+//
+//go:embed schema/entry.schema.json
+var builtinSchema []byte
+
+// entrySchema is the -assert-schema schema entries are checked against, or nil.
+var entrySchema *jsonSchema
+
+// jsonSchema is the subset of JSON Schema needed to pin down the shape of
+// entries: type, properties, required, additionalProperties, items, enum,
+// minimum and maximum. Other keywords are ignored.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *additionalProperties  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []any                  `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+}
+
+// schemaTypes is a type keyword, which is either one type or a list of them.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = schemaTypes{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// additionalProperties is either false, forbidding unlisted properties, or a
+// schema they must match.
+type additionalProperties struct {
+	forbidden bool
+	schema    *jsonSchema
+}
+
+func (a *additionalProperties) UnmarshalJSON(data []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(data, &allowed); err == nil {
+		a.forbidden = !allowed
+		return nil
+	}
+	return json.Unmarshal(data, &a.schema)
+}
+
+// loadSchema parses the -assert-schema schema, where builtin means the one
+// embedded in the binary.
+func loadSchema(fname string) *jsonSchema {
+	data := builtinSchema
+	if fname != "builtin" {
+		var err error
+		data, err = os.ReadFile(fname)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var schema jsonSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		log.Fatalf("Parsing -assert-schema %s: %s", fname, err)
+	}
+	if fname == "builtin" {
+		schema.allowEmptyPolicy(*emptyPolicy)
+	}
+	return &schema
+}
+
+// allowEmptyPolicy loosens an entry schema written for the default
+// -empty-policy so that the entry's empty scalars may be null or missing, as
+// policy writes them. The id is always written, so it stays as it is.
+func (s *jsonSchema) allowEmptyPolicy(policy string) {
+	for name, prop := range s.Properties {
+		if name == "id" || !slices.ContainsFunc(prop.Type, isScalarType) {
+			continue
+		}
+		switch policy {
+		case emptyAsNull:
+			prop.Type = append(prop.Type, "null")
+			if len(prop.Enum) > 0 {
+				prop.Enum = append(prop.Enum, nil)
+			}
+		case emptyOmitted:
+			s.Required = slices.DeleteFunc(s.Required, func(required string) bool { return required == name })
+		}
+	}
+}
+
+func isScalarType(t string) bool {
+	return t == "string" || t == "integer" || t == "number"
+}
+
+// checkSchema validates an entry against -assert-schema before it is written,
+// exiting on a violation under -strict and logging it otherwise.
+func checkSchema(entry Entry) {
+	if entrySchema == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		log.Fatal(err)
+	}
+	problems := entrySchema.validate(v, "")
+	if len(problems) == 0 {
+		return
+	}
+	if *strict {
+		log.Fatalf("Entry %s doesn't match -assert-schema: %s", entry.Id, problems[0])
+	}
+	for _, problem := range problems {
+		log.Printf("Warning: entry %s doesn't match -assert-schema: %s", entry.Id, problem)
+	}
+}
+
+// validate returns how v, decoded from JSON, violates the schema. path is
+// where v is in the entry, as a JSON pointer.
+func (s *jsonSchema) validate(v any, path string) []string {
+	if len(s.Type) > 0 && !slices.Contains(s.Type, jsonType(v)) {
+		if !(jsonType(v) == "integer" && slices.Contains(s.Type, "number")) {
+			return []string{fmt.Sprintf("%s: got %s, want %s", pathOrRoot(path), jsonType(v), joinTypes(s.Type))}
+		}
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return e == v }) {
+		return []string{fmt.Sprintf("%s: %v isn't one of %v", pathOrRoot(path), v, s.Enum)}
+	}
+
+	var problems []string
+	switch v := v.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			problems = append(problems, fmt.Sprintf("%s: %v is below the minimum %v", pathOrRoot(path), v, *s.Minimum))
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			problems = append(problems, fmt.Sprintf("%s: %v is above the maximum %v", pathOrRoot(path), v, *s.Maximum))
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				problems = append(problems, s.Items.validate(item, fmt.Sprintf("%s/%d", path, i))...)
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing %s", pathOrRoot(path), name))
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			switch {
+			case ok:
+				problems = append(problems, prop.validate(v[name], path+"/"+name)...)
+			case s.AdditionalProperties == nil:
+			case s.AdditionalProperties.forbidden:
+				problems = append(problems, fmt.Sprintf("%s: unexpected %s", pathOrRoot(path), name))
+			case s.AdditionalProperties.schema != nil:
+				problems = append(problems, s.AdditionalProperties.schema.validate(v[name], path+"/"+name)...)
+			}
+		}
+	}
+	return problems
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value, calling
+// whole numbers integers.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func joinTypes(types schemaTypes) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	return fmt.Sprintf("one of %v", []string(types))
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
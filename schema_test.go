@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	var schema jsonSchema
+	err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["id"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "string"},
+			"views": {"type": "integer", "minimum": 0},
+			"accuracy": {"type": ["integer", "null"], "maximum": 16},
+			"latitude": {"type": "number"},
+			"media": {"enum": ["photo", "video"]},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"local": {"type": "object", "additionalProperties": {"type": "string"}}
+		}
+	}`), &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		entry string
+		want  []string
+	}{
+		{"valid", `{"id":"1001","views":812,"accuracy":null,"latitude":54.5,"media":"photo","tags":["fell"],"local":{"note":"x"}}`, nil},
+		{"integers are numbers", `{"id":"1001","latitude":54}`, nil},
+		{"number became a string", `{"id":"1001","views":"812"}`, []string{"/views: got string, want integer"}},
+		{"missing required", `{"views":812}`, []string{"/: missing id"}},
+		{"unexpected property", `{"id":"1001","title":"Tarn"}`, []string{"/: unexpected title"}},
+		{"below the minimum", `{"id":"1001","views":-1}`, []string{"/views: -1 is below the minimum 0"}},
+		{"above the maximum", `{"id":"1001","accuracy":17}`, []string{"/accuracy: 17 is above the maximum 16"}},
+		{"not in the enum", `{"id":"1001","media":"screencast"}`, []string{"/media: screencast isn't one of [photo video]"}},
+		{"bad item", `{"id":"1001","tags":["fell",3]}`, []string{"/tags/1: got integer, want string"}},
+		{"bad additional property", `{"id":"1001","local":{"note":true}}`, []string{"/local/note: got boolean, want string"}},
+		{"not an object", `[]`, []string{"/: got array, want object"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v any
+			if err := json.Unmarshal([]byte(tt.entry), &v); err != nil {
+				t.Fatal(err)
+			}
+			if got := schema.validate(v, ""); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinSchemaAcceptsGoldenEntries(t *testing.T) {
+	schema := loadSchema("builtin")
+	fnames, err := filepath.Glob("testdata/entries/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fname := range fnames {
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			t.Fatal(err)
+		}
+		for _, problem := range schema.validate(v, "") {
+			t.Errorf("%s: %s", fname, problem)
+		}
+	}
+}
+
+func TestAssertSchemaStrict(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "schema.json")
+	writeFile(t, fname, `{"type":"object","properties":{"views":{"type":"string"}}}`)
+	oldSchema, oldStrict := entrySchema, *strict
+	defer func() { entrySchema, *strict = oldSchema, oldStrict }()
+	entrySchema = loadSchema(fname)
+	*strict = true
+	expectFatal(t, "Entry 1001 doesn't match -assert-schema: /views: got integer, want string", func() {
+		checkSchema(Entry{Id: "1001", Views: 812})
+	})
+}
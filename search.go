@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+const (
+	searchPerPage = 250
+	// flickr.photos.search returns at most this many results for a query,
+	// however many pages it claims to have.
+	searchResultCap = 4000
+)
+
+type searchPhoto struct {
+	Id        string     `json:"id"`
+	Owner     string     `json:"owner"`
+	OwnerName string     `json:"ownername"`
+	Title     string     `json:"title"`
+	DateTaken string     `json:"datetaken"`
+	License   flexString `json:"license"`
+	Latitude  flexString `json:"latitude"`
+	Longitude flexString `json:"longitude"`
+	Accuracy  flexInt    `json:"accuracy"`
+	URLL      string     `json:"url_l"`
+	WidthL    int        `json:"width_l"`
+	HeightL   int        `json:"height_l"`
+	// Not a documented extra, but returned when requested
+	ContentType flexInt `json:"content_type"`
+	Views       flexInt `json:"views"`
+	IconServer  string  `json:"iconserver"`
+	IconFarm    int     `json:"iconfarm"`
+}
+
+type searchResults struct {
+	Page  int           `json:"page"`
+	Pages int           `json:"pages"`
+	Total flexInt       `json:"total"`
+	Photo []searchPhoto `json:"photo"`
+}
+
+// searchPage fetches one page of flickr.photos.search results.
+func searchPage(ctx context.Context, params url.Values, extras string, page int) (searchResults, error) {
+	callParams := make(map[string]string)
+	for k := range params {
+		callParams[k] = params.Get(k)
+	}
+	if extras != "" {
+		callParams["extras"] = extras
+	}
+	callParams["per_page"] = fmt.Sprintf("%d", searchPerPage)
+	callParams["page"] = fmt.Sprintf("%d", page)
+
+	var resp struct {
+		Photos searchResults `json:"photos"`
+	}
+	err := callFlickr(ctx, "flickr.photos.search", &resp, callParams)
+	return resp.Photos, err
+}
+
+// searchAll pages through every result Flickr will return for a search,
+// calling fn for each photo.
+func searchAll(ctx context.Context, params url.Values, extras string, fn func(searchPhoto)) error {
+	for page := 1; ; page++ {
+		results, err := searchPage(ctx, params, extras, page)
+		if err != nil {
+			return err
+		}
+		for _, photo := range results.Photo {
+			fn(photo)
+		}
+
+		if page >= results.Pages {
+			return nil
+		}
+		if page*searchPerPage >= searchResultCap {
+			log.Printf("Warning: search matched %d photos but Flickr only returns the first %d", results.Total, searchResultCap)
+			return nil
+		}
+	}
+}
+
+// searchIngest pages through flickr.photos.search, returning partial entries
+// built from the extras so that no per-photo calls are needed.
+func searchIngest(ctx context.Context, params url.Values) ([]Entry, error) {
+	var entries []Entry
+	err := searchAll(ctx, params, "geo,owner_name,date_taken,url_l,license,content_type,views,icon_server", func(photo searchPhoto) {
+		entries = append(entries, photo.entry())
+	})
+	return entries, err
+}
+
+func (p searchPhoto) entry() Entry {
+	var sizes []PictureSize
+	if p.URLL != "" {
+		sizes = validSizes([]PictureSize{{Label: "Large", Width: p.WidthL, Height: p.HeightL, Source: p.URLL}})
+	}
+	entry := Entry{
+		Id:               p.Id,
+		Sizes:            sizes,
+		OwnerNSID:        p.Owner,
+		OwnerUsername:    p.OwnerName,
+		OwnerIcon:        buddyIconURL(p.IconFarm, p.IconServer, p.Owner),
+		Title:            p.Title,
+		DateTaken:        p.DateTaken,
+		Latitude:         string(p.Latitude),
+		Longitude:        string(p.Longitude),
+		LocationAccuracy: p.Accuracy,
+		Webpage:          "https://www.flickr.com/photos/" + p.Owner + "/" + p.Id,
+		License:          string(p.License),
+		ContentType:      int(p.ContentType),
+		Views:            int(p.Views),
+	}
+	parseCoordinates(&entry)
+	if *keepUnlocated {
+		hasLocation := entry.LatitudeF != nil
+		entry.HasLocation = &hasLocation
+	}
+	describeLicense(&entry)
+	return entry
+}
+
+// searchRegion writes the results of a search to a region's output, skipping
+// photos already present or blocked. Search results don't say where a photo
+// is beyond its coordinates, or how it may be used, so checkSearchFlags
+// rejects the filters that need those.
+func searchRegion(ctx context.Context, region string, params url.Values, blocked map[string]bool) *RegionStats {
+	log.Printf("Searching for region %s", region)
+	stats := newRegionStats()
+	startCalls := apiCalls.Load()
+	defer func() { stats.APICalls = apiCalls.Load() - startCalls }()
+
+	entries, err := searchIngest(ctx, params)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, fname := range regionFiles(region) {
+		removeBlocked(fname, blocked)
+	}
+	out, existingEntries := openRegionOutput(region)
+	defer out.Close()
+	var n int
+	for _, entry := range entries {
+		if blocked[entry.Id] {
+			n++
+			continue
+		}
+		if _, ok := existingEntries[entry.Id]; ok {
+			continue
+		}
+		if !contentTypeAllowed(entry.ContentType) {
+			stats.Skipped[SkipContentType]++
+			continue
+		}
+		if entry.Views < *minViews {
+			stats.Skipped[SkipTooFewViews]++
+			continue
+		}
+		if *requireLocation && entry.LatitudeF == nil {
+			stats.Skipped[SkipNoLocation]++
+			continue
+		}
+		entry.Region = region
+		entry.RetrievedAt = time.Now().UTC()
+		entry.ContentHash = contentHash(entry)
+		out.Write(entry)
+		existingEntries[entry.Id] = entry
+		stats.Written++
+	}
+	if n > 0 {
+		log.Printf("Blocked %d search results in region %s", n, region)
+	}
+	return stats
+}
+
+// checkSearchFlags rejects flags -search can't honour.
+func checkSearchFlags() {
+	switch {
+	case indexed(), *appendTo != "":
+		log.Fatal("-search doesn't support -index db or -append-to")
+	case *allowWoeid != "", *denyWoeid != "":
+		log.Fatal("-search can't filter by -allow-woeid or -deny-woeid, since search results don't say which places a photo is in")
+	case *requireUsage != "":
+		log.Fatal("-search can't filter by -require-usage, since search results don't say how a photo may be used")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestSearchRegion(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	oldViews, oldLocation := *minViews, *requireLocation
+	defer func() { *minViews, *requireLocation = oldViews, oldLocation }()
+	*minViews, *requireLocation = 10, true
+
+	stats := searchRegion(context.Background(), "region", url.Values{"bbox": {"-3.3,54.4,-3.1,54.5"}}, nil)
+	if stats.Written != 1 || stats.Skipped[SkipNoLocation] != 1 || stats.Skipped[SkipTooFewViews] != 1 {
+		t.Errorf("wrote %d and skipped %v, want 2001 written and one skipped for each filter", stats.Written, stats.Skipped)
+	}
+	entries := readEntries(outputFile("region"))
+	if got := entryIds(entries); !slices.Equal(got, []string{"2001"}) {
+		t.Fatalf("output has %v, want [2001]", got)
+	}
+	entry := entries[0]
+	if entry.OwnerNSID != "12345678@N00" {
+		t.Errorf("ownerNsid is %q, want the search result's owner", entry.OwnerNSID)
+	}
+	if want := buddyIconURL(66, "65535", "12345678@N00"); entry.OwnerIcon != want {
+		t.Errorf("ownerIcon is %q, want %q", entry.OwnerIcon, want)
+	}
+	if entry.ContentHash == "" || entry.ContentHash != contentHash(entry) {
+		t.Errorf("contentHash is %q, want %q", entry.ContentHash, contentHash(entry))
+	}
+}
+
+func TestSearchRejectsWoeidFilter(t *testing.T) {
+	old := *allowWoeid
+	defer func() { *allowWoeid = old }()
+	*allowWoeid = "12602186"
+	expectFatal(t, "-search can't filter by -allow-woeid", checkSearchFlags)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+//go:embed testdata/selftest
+var selftestFiles embed.FS
+
+const selftestGolden = "testdata/selftest/golden.ndjson"
+
+// fixtureDoer answers Flickr API requests from the files in responses named
+// by responseName, and reports any other photo as not found.
+type fixtureDoer struct {
+	files fs.FS
+}
+
+func (d fixtureDoer) Do(req *http.Request) (*http.Response, error) {
+	body, err := fs.ReadFile(d.files, "responses/"+responseName(req.URL.Query()))
+	if err != nil {
+		body = []byte(`{"stat":"fail","code":1,"message":"Photo not found"}`)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// responseName names the file a response to an API call with query is kept
+// in, both for selftest fixtures and -save-raw: the method followed by every
+// other parameter that affects the response, sorted, such as
+// flickr.photos.getInfo.photo_id=1001.json. Names that would be too long for
+// a file name use a hash of those parameters instead.
+func responseName(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for k := range query {
+		switch k {
+		case "method", "api_key", "format", "nojsoncallback":
+			continue
+		}
+		params = append(params, url.QueryEscape(k)+"="+url.QueryEscape(query.Get(k)))
+	}
+	slices.Sort(params)
+	name := strings.Join(append([]string{query.Get("method")}, params...), ".")
+	if len(name) > 200 {
+		sum := sha256.Sum256([]byte(name))
+		name = query.Get("method") + "." + hex.EncodeToString(sum[:8])
+	}
+	return name + ".json"
+}
+
+// runSelftest hydrates the ids in testdata/selftest/ingest.txt from the
+// bundled fixtures, without an API key or network access, and compares the
+// output with testdata/selftest/golden.ndjson. It should be run without other
+// flags, since they change the output.
+func runSelftest(args []string) {
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	update := flags.Bool("update", false, "Rewrite "+selftestGolden+" with the current output instead of comparing")
+	flags.Parse(args)
+
+	fixtures, err := fs.Sub(selftestFiles, "testdata/selftest")
+	if err != nil {
+		log.Fatal(err)
+	}
+	ingest, err := fixtures.Open("ingest.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	ids := readIDLines(ingest)
+	ingest.Close()
+
+	httpClient = fixtureDoer{files: fixtures}
+	keys.keys = []*apiKey{newAPIKey("selftest")}
+	requestInterval = 0
+
+	got := selftestHydrate(ids)
+
+	if *update {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, entry := range got {
+			if err := enc.Encode(entry); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(selftestGolden, buf.Bytes(), 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %d entries to %s", len(got), selftestGolden)
+		return
+	}
+
+	golden, err := selftestFiles.ReadFile(selftestGolden)
+	if err != nil {
+		log.Fatal(err)
+	}
+	want := decodeEntries(golden)
+
+	var mismatches []string
+	if len(got) != len(want) {
+		mismatches = append(mismatches, fmt.Sprintf("got %d entries, want %d", len(got), len(want)))
+	}
+	for i := range min(len(got), len(want)) {
+		if got[i].Id != want[i].Id {
+			mismatches = append(mismatches, fmt.Sprintf("entry %d: got id %s, want %s", i+1, got[i].Id, want[i].Id))
+			continue
+		}
+		if reflect.DeepEqual(got[i], want[i]) {
+			continue
+		}
+		for _, change := range diffFields(want[i], got[i]) {
+			gotJSON, _ := json.Marshal(change.New)
+			wantJSON, _ := json.Marshal(change.Old)
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s: got %s, want %s", got[i].Id, change.Field, gotJSON, wantJSON))
+		}
+	}
+	if len(mismatches) > 0 {
+		log.Printf("Selftest failed:\n%s", strings.Join(mismatches, "\n"))
+		os.Exit(1)
+	}
+	log.Printf("Selftest passed: %d entries match %s", len(got), selftestGolden)
+}
+
+// selftestHydrate runs processRegion over ids in a scratch directory, so that
+// the side files it writes don't land in the real output, and returns the
+// entries written with the fields that vary between runs cleared.
+func selftestHydrate(ids []string) []Entry {
+	cwd, err := os.Getwd()
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("", "hydrator-selftest")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		log.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	*outDir = "out"
+	if err := os.Mkdir("out", dirMode); err != nil {
+		log.Fatal(err)
+	}
+
+	loadLicenses(context.Background())
+
+	var buf bytes.Buffer
+	out := newEntryWriter(&buf)
+	_, err = processRegion(context.Background(), "selftest", ids, out, make(map[string]Entry))
+	out.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entries := decodeEntries(buf.Bytes())
+	for i := range entries {
+		entries[i].RetrievedAt = time.Time{}
+		if entries[i].Provenance != nil {
+			entries[i].Provenance.FetchedAt = time.Time{}
+		}
+	}
+	return entries
+}
+
+func decodeEntries(data []byte) []Entry {
+	var entries []Entry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var entry Entry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,222 @@
+package main
+
+import "strings"
+
+var canonicalSizeLabels = map[string]string{
+	"Square":       "square-small",
+	"Large Square": "square-large",
+	"Thumbnail":    "thumbnail",
+	"Small":        "small",
+	"Small 320":    "small-320",
+	"Small 400":    "small-400",
+	"Medium":       "medium",
+	"Medium 640":   "medium-640",
+	"Medium 800":   "medium-800",
+	"Large":        "large",
+	"Large 1600":   "large-1600",
+	"Large 2048":   "large-2048",
+	"X-Large 3K":   "xlarge-3k",
+	"X-Large 4K":   "xlarge-4k",
+	"X-Large 5K":   "xlarge-5k",
+	"X-Large 6K":   "xlarge-6k",
+	"Original":     "original",
+}
+
+// canonicalSizeLabel maps Flickr's size labels to lowercase, hyphenated names.
+// Labels missing from the table are lowercased with spaces replaced.
+func canonicalSizeLabel(label string) string {
+	if canonical, ok := canonicalSizeLabels[label]; ok {
+		return canonical
+	}
+	return strings.ReplaceAll(strings.ToLower(label), " ", "-")
+}
+
+// largestSize returns the size with the most pixels, which Flickr doesn't
+// necessarily list last.
+func largestSize(sizes []PictureSize) (PictureSize, bool) {
+	var largest PictureSize
+	for _, size := range sizes {
+		if size.Width*size.Height > largest.Width*largest.Height {
+			largest = size
+		}
+	}
+	return largest, len(sizes) > 0
+}
+
+// sizeTiers are the tiers -split-by-size writes, each holding sizes whose
+// longest side is at most maxSide pixels (0 for unbounded).
+var sizeTiers = []struct {
+	name    string
+	maxSide int
+}{
+	{"small", 400},
+	{"medium", 800},
+	{"large", 0},
+}
+
+type TierSize struct {
+	Id  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// tierSizes returns the largest size of each tier a photo has, keyed by tier.
+func tierSizes(sizes []PictureSize) map[string]PictureSize {
+	tiers := make(map[string]PictureSize)
+	for _, size := range sizes {
+		side := max(size.Width, size.Height)
+		minSide := 0
+		for _, tier := range sizeTiers {
+			if side > minSide && (tier.maxSide == 0 || side <= tier.maxSide) {
+				if best, ok := tiers[tier.name]; !ok || side > max(best.Width, best.Height) {
+					tiers[tier.name] = size
+				}
+				break
+			}
+			minSide = tier.maxSide
+		}
+	}
+	return tiers
+}
+
+const (
+	displayClosest      = "closest"
+	displayLargestUnder = "largest-under"
+)
+
+// pickSize chooses the size to display a photo at, per -display-strategy,
+// comparing widths as displayed after rotation. It returns false if the
+// strategy's width flag is unset or there are no sizes.
+//
+// closest picks the size whose width is nearest -display-width. largest-under
+// picks the widest size no wider than -display-max-width, to bound bandwidth,
+// falling back to the narrowest when all are wider.
+func pickSize(sizes []PictureSize, rotation int) (PictureSize, bool) {
+	if len(sizes) == 0 {
+		return PictureSize{}, false
+	}
+	width := func(size PictureSize) int {
+		w, _ := orientedDimensions(size, rotation)
+		return w
+	}
+	switch *displayStrategy {
+	case displayClosest:
+		if *displayWidth <= 0 {
+			return PictureSize{}, false
+		}
+		best := sizes[0]
+		for _, size := range sizes[1:] {
+			if absInt(width(size)-*displayWidth) < absInt(width(best)-*displayWidth) {
+				best = size
+			}
+		}
+		return best, true
+	case displayLargestUnder:
+		if *displayMaxWidth <= 0 {
+			return PictureSize{}, false
+		}
+		var best PictureSize
+		found := false
+		narrowest := sizes[0]
+		for _, size := range sizes {
+			if width(size) <= *displayMaxWidth && (!found || width(size) > width(best)) {
+				best, found = size, true
+			}
+			if width(size) < width(narrowest) {
+				narrowest = size
+			}
+		}
+		if !found {
+			return narrowest, true
+		}
+		return best, true
+	}
+	return PictureSize{}, false
+}
+
+// orientedDimensions returns the width and height of a size as displayed,
+// swapping them if the photo is rotated a quarter turn.
+func orientedDimensions(size PictureSize, rotation int) (width, height int) {
+	if rotation%180 == 90 {
+		return size.Height, size.Width
+	}
+	return size.Width, size.Height
+}
+
+// orientation classifies displayed dimensions.
+func orientation(width, height int) string {
+	switch {
+	case width > height:
+		return "landscape"
+	case width < height:
+		return "portrait"
+	}
+	return "square"
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// sizeSuffixes are the URL suffixes of the sizes whose files are named with a
+// photo's secret from getInfo. The larger sizes have secrets of their own that
+// only getSizes gives, so can't be rebuilt.
+var sizeSuffixes = map[string]string{
+	"Square":       "_s",
+	"Large Square": "_q",
+	"Thumbnail":    "_t",
+	"Small":        "_m",
+	"Small 320":    "_n",
+	"Small 400":    "_w",
+	"Medium":       "",
+	"Medium 640":   "_z",
+	"Medium 800":   "_c",
+	"Large":        "_b",
+}
+
+// photoFiles is what getInfo says about where a photo's files are.
+type photoFiles struct {
+	id             string
+	server         string
+	farm           int
+	secret         string
+	originalSecret string // Only given if the owner allows downloads
+	originalFormat string
+}
+
+// sourceURL returns where the file for a size label is, if that can be worked
+// out.
+func (f photoFiles) sourceURL(label string) (string, bool) {
+	if f.server == "" {
+		return "", false
+	}
+	if label == "Original" {
+		if f.originalSecret == "" || f.originalFormat == "" {
+			return "", false
+		}
+		return staticURL(f.farm, f.server, f.id+"_"+f.originalSecret+"_o."+f.originalFormat), true
+	}
+	suffix, ok := sizeSuffixes[label]
+	if !ok || f.secret == "" {
+		return "", false
+	}
+	return staticURL(f.farm, f.server, f.id+"_"+f.secret+suffix+".jpg"), true
+}
+
+// repairSources fills in the source of sizes Flickr listed without one,
+// returning how many it could. Those it can't are left for validSizes to drop.
+func repairSources(sizes []PictureSize, files photoFiles) int {
+	var repaired int
+	for i := range sizes {
+		if sizes[i].Source != "" || sizes[i].Width <= 0 || sizes[i].Height <= 0 {
+			continue
+		}
+		if url, ok := files.sourceURL(sizes[i].Label); ok {
+			sizes[i].Source = url
+			repaired++
+		}
+	}
+	return repaired
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"os"
+	"testing"
+)
+
+func TestCanonicalSizeLabel(t *testing.T) {
+	tests := []struct {
+		label, want string
+	}{
+		{"Square", "square-small"},
+		{"Large Square", "square-large"},
+		{"Thumbnail", "thumbnail"},
+		{"Small", "small"},
+		{"Small 320", "small-320"},
+		{"Small 400", "small-400"},
+		{"Medium", "medium"},
+		{"Medium 640", "medium-640"},
+		{"Medium 800", "medium-800"},
+		{"Large", "large"},
+		{"Large 1600", "large-1600"},
+		{"Large 2048", "large-2048"},
+		{"X-Large 3K", "xlarge-3k"},
+		{"X-Large 4K", "xlarge-4k"},
+		{"X-Large 5K", "xlarge-5k"},
+		{"X-Large 6K", "xlarge-6k"},
+		{"Original", "original"},
+		// Labels Flickr adds later fall back to a guess.
+		{"Large 8K", "large-8k"},
+	}
+	for _, tt := range tests {
+		if got := canonicalSizeLabel(tt.label); got != tt.want {
+			t.Errorf("canonicalSizeLabel(%q) = %q, want %q", tt.label, got, tt.want)
+		}
+	}
+	if len(canonicalSizeLabels) != len(tests)-1 {
+		t.Errorf("the table has %d labels and the test %d, want every standard label tested", len(canonicalSizeLabels), len(tests)-1)
+	}
+}
+
+func TestCanonicalSizeLabelsKeepRawLabel(t *testing.T) {
+	useFixtures(t)
+	old := *canonLabels
+	defer func() { *canonLabels = old }()
+	*canonLabels = true
+
+	entry, _, err := createEntry(context.Background(), "1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range entry.Sizes {
+		if size.Label == "" || size.CanonicalLabel != canonicalSizeLabel(size.Label) {
+			t.Errorf("size has label %q and canonical label %q, want both", size.Label, size.CanonicalLabel)
+		}
+	}
+}
+
+// unorderedSizes is a getSizes response listing the largest size first and
+// the widest in the middle.
+const unorderedSizes = `{"sizes":{"size":[
+{"label":"Large","width":1024,"height":1024,"source":"https://live.staticflickr.com/65535/1001_abc_b.jpg"},
+{"label":"Panorama","width":1600,"height":400,"source":"https://live.staticflickr.com/65535/1001_abc_h.jpg"},
+{"label":"Small","width":240,"height":240,"source":"https://live.staticflickr.com/65535/1001_abc_m.jpg"}
+]},"stat":"ok"}`
+
+func TestSizeSummary(t *testing.T) {
+	useFixtures(t)
+	httpClient = overrideDoer{next: httpClient, bodies: map[string]string{"flickr.photos.getSizes": unorderedSizes}}
+
+	entry, skip, err := createEntry(context.Background(), "1001")
+	if err != nil || skip != NotSkipped {
+		t.Fatalf("got skip %q and error %v, want the entry", skip, err)
+	}
+	// The largest size is the one with the most pixels, wherever it's listed.
+	if entry.SizeCount != 3 || entry.MaxWidth != 1024 || entry.MaxHeight != 1024 {
+		t.Errorf("got %d sizes, largest %dx%d, want 3 sizes, largest 1024x1024", entry.SizeCount, entry.MaxWidth, entry.MaxHeight)
+	}
+
+	old := *minWidth
+	defer func() { *minWidth = old }()
+	for _, tt := range []struct {
+		minWidth int
+		want     SkipReason
+	}{
+		{1024, NotSkipped},
+		{1025, SkipTooSmall},
+	} {
+		*minWidth = tt.minWidth
+		if _, skip, err := createEntry(context.Background(), "1001"); err != nil || skip != tt.want {
+			t.Errorf("with -min-width %d got skip %q and error %v, want skip %q", tt.minWidth, skip, err, tt.want)
+		}
+	}
+}
+
+func TestTierSizes(t *testing.T) {
+	tests := []struct {
+		name  string
+		sides []int
+		want  map[string]int // Tier to the longest side of its size
+	}{
+		{"every tier", []int{100, 320, 500, 800, 1024, 2048}, map[string]int{"small": 320, "medium": 800, "large": 2048}},
+		{"at the boundaries", []int{400, 401, 801}, map[string]int{"small": 400, "medium": 401, "large": 801}},
+		{"small only", []int{75, 150}, map[string]int{"small": 150}},
+		{"no medium", []int{240, 1600}, map[string]int{"small": 240, "large": 1600}},
+		{"none", nil, map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sizes []PictureSize
+			for _, side := range tt.sides {
+				// Portrait sizes are tiered by their height.
+				sizes = append(sizes, PictureSize{Width: side * 2 / 3, Height: side})
+			}
+			got := make(map[string]int)
+			for tier, size := range tierSizes(sizes) {
+				got[tier] = size.Height
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got tiers %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitBySize(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	old := *splitBySize
+	defer func() { *splitBySize = old }()
+	*splitBySize = true
+
+	out, existing := openRegionOutput("region")
+	if _, err := processRegion(context.Background(), "region", []string{"1001"}, out, existing); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	want := map[string]string{
+		"small":  "https://farm66.staticflickr.com/65535/1001_abc_n.jpg",
+		"medium": "https://live.staticflickr.com/65535/1001_abc.jpg",
+		"large":  "https://live.staticflickr.com/65535/1001_abc_b.jpg",
+	}
+	for tier, url := range want {
+		data, err := os.ReadFile(outPath("region.sizes-" + tier + ".ndjson"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var size TierSize
+		if err := json.Unmarshal(data, &size); err != nil {
+			t.Fatal(err)
+		}
+		if size != (TierSize{Id: "1001", URL: url}) {
+			t.Errorf("%s tier has %+v, want 1001 at %s", tier, size, url)
+		}
+	}
+}
+
+func TestPickSize(t *testing.T) {
+	sizes := []PictureSize{
+		{Label: "Medium", Width: 500, Height: 375},
+		{Label: "Large", Width: 1024, Height: 768},
+		{Label: "Thumbnail", Width: 100, Height: 75},
+		{Label: "Medium 800", Width: 800, Height: 600},
+	}
+	tests := []struct {
+		strategy string
+		width    int
+		rotation int
+		want     string
+	}{
+		{displayClosest, 700, 0, "Medium 800"},
+		{displayClosest, 600, 0, "Medium"},
+		{displayClosest, 5000, 0, "Large"},
+		{displayClosest, 1, 0, "Thumbnail"},
+		// Rotated a quarter turn, widths are the heights: 375, 768, 75, 600.
+		{displayClosest, 700, 90, "Large"},
+		{displayLargestUnder, 1024, 0, "Large"},
+		{displayLargestUnder, 1023, 0, "Medium 800"},
+		{displayLargestUnder, 700, 0, "Medium"},
+		{displayLargestUnder, 50, 0, "Thumbnail"},
+		{displayLargestUnder, 700, 270, "Medium 800"},
+	}
+	oldStrategy, oldWidth, oldMax := *displayStrategy, *displayWidth, *displayMaxWidth
+	defer func() { *displayStrategy, *displayWidth, *displayMaxWidth = oldStrategy, oldWidth, oldMax }()
+	for _, tt := range tests {
+		*displayStrategy, *displayWidth, *displayMaxWidth = tt.strategy, 0, 0
+		if tt.strategy == displayClosest {
+			*displayWidth = tt.width
+		} else {
+			*displayMaxWidth = tt.width
+		}
+		size, ok := pickSize(sizes, tt.rotation)
+		if !ok || size.Label != tt.want {
+			t.Errorf("%s %d rotated %d: got %q, %v, want %q", tt.strategy, tt.width, tt.rotation, size.Label, ok, tt.want)
+		}
+	}
+
+	// Without its width flag, a strategy picks nothing.
+	for _, strategy := range []string{displayClosest, displayLargestUnder} {
+		*displayStrategy, *displayWidth, *displayMaxWidth = strategy, 0, 0
+		if size, ok := pickSize(sizes, 0); ok {
+			t.Errorf("%s without a width picked %q, want nothing", strategy, size.Label)
+		}
+	}
+}
+
+func TestOrientedDimensions(t *testing.T) {
+	size := PictureSize{Width: 1600, Height: 1200}
+	tests := []struct {
+		rotation      int
+		width, height int
+		orientation   string
+	}{
+		{0, 1600, 1200, "landscape"},
+		{90, 1200, 1600, "portrait"},
+		{180, 1600, 1200, "landscape"},
+		{270, 1200, 1600, "portrait"},
+	}
+	for _, tt := range tests {
+		width, height := orientedDimensions(size, tt.rotation)
+		if width != tt.width || height != tt.height {
+			t.Errorf("rotated %d: got %dx%d, want %dx%d", tt.rotation, width, height, tt.width, tt.height)
+		}
+		if got := orientation(width, height); got != tt.orientation {
+			t.Errorf("rotated %d: got %s, want %s", tt.rotation, got, tt.orientation)
+		}
+	}
+}
+
+func TestCreateEntryRotated(t *testing.T) {
+	useFixtures(t)
+	// 1003 is stored 240x180 and rotated a quarter turn, so is displayed
+	// portrait.
+	entry, _, err := createEntry(context.Background(), "1003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Rotation != 90 {
+		t.Fatalf("rotation is %d, want 90", entry.Rotation)
+	}
+	if entry.MaxWidth != 240 || entry.MaxHeight != 180 {
+		t.Errorf("max size is %dx%d, want the stored 240x180", entry.MaxWidth, entry.MaxHeight)
+	}
+	if entry.OrientedWidth != 180 || entry.OrientedHeight != 240 {
+		t.Errorf("oriented size is %dx%d, want 180x240", entry.OrientedWidth, entry.OrientedHeight)
+	}
+	if entry.Orientation != "portrait" || entry.AspectRatio != 0.75 {
+		t.Errorf("orientation is %s with aspect ratio %g, want portrait at 0.75", entry.Orientation, entry.AspectRatio)
+	}
+}
+
+func TestRepairSources(t *testing.T) {
+	files := photoFiles{id: "1001", server: "65535", farm: 66, secret: "abc", originalSecret: "orig", originalFormat: "jpg"}
+	tests := []struct {
+		name  string
+		files photoFiles
+		size  PictureSize
+		want  string
+	}{
+		{"has a source", files, PictureSize{Label: "Small 320", Width: 320, Height: 240, Source: "https://live.staticflickr.com/65535/1001_abc_n.jpg"}, "https://live.staticflickr.com/65535/1001_abc_n.jpg"},
+		{"missing", files, PictureSize{Label: "Small 320", Width: 320, Height: 240}, "https://farm66.staticflickr.com/65535/1001_abc_n.jpg"},
+		{"medium has no suffix", files, PictureSize{Label: "Medium", Width: 500, Height: 375}, "https://farm66.staticflickr.com/65535/1001_abc.jpg"},
+		{"original", files, PictureSize{Label: "Original", Width: 4000, Height: 3000}, "https://farm66.staticflickr.com/65535/1001_orig_o.jpg"},
+		{"original without its secret", photoFiles{id: "1001", server: "65535", farm: 66, secret: "abc"}, PictureSize{Label: "Original", Width: 4000, Height: 3000}, ""},
+		{"size with a secret of its own", files, PictureSize{Label: "Large 2048", Width: 2048, Height: 1536}, ""},
+		{"no server", photoFiles{id: "1001", secret: "abc"}, PictureSize{Label: "Small 320", Width: 320, Height: 240}, ""},
+		{"degenerate", files, PictureSize{Label: "Small 320"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sizes := []PictureSize{tt.size}
+			repaired := repairSources(sizes, tt.files)
+			if sizes[0].Source != tt.want {
+				t.Errorf("source is %q, want %q", sizes[0].Source, tt.want)
+			}
+			if wantRepaired := tt.size.Source == "" && tt.want != ""; (repaired == 1) != wantRepaired {
+				t.Errorf("repaired %d sizes, want repaired: %v", repaired, wantRepaired)
+			}
+		})
+	}
+}
+
+func TestCreateEntryRepairsMissingSource(t *testing.T) {
+	useFixtures(t)
+	// getSizes gives 1001's Small 320 without a source, and no size without
+	// one is kept.
+	entry, _, err := createEntry(context.Background(), "1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, size := range entry.Sizes {
+		if size.Source == "" {
+			t.Errorf("%s has no source", size.Label)
+		}
+		if size.Label == "Small 320" {
+			found = true
+			if want := "https://farm66.staticflickr.com/65535/1001_abc_n.jpg"; size.Source != want {
+				t.Errorf("Small 320 has source %s, want it rebuilt as %s", size.Source, want)
+			}
+		}
+	}
+	if !found {
+		t.Error("Small 320 was dropped, want its source rebuilt")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// apiCalls counts every request made to the Flickr API.
+var apiCalls atomic.Int64
+
+type RegionStats struct {
+	Written   int                `json:"written"`
+	Refetched int                `json:"refetched"` // Of those written, how many replaced an existing entry
+	Skipped   map[SkipReason]int `json:"skipped"`
+	Failed    int                `json:"failed"`
+	APICalls  int64              `json:"apiCalls"`
+}
+
+func newRegionStats() *RegionStats {
+	return &RegionStats{Skipped: make(map[SkipReason]int)}
+}
+
+func (s *RegionStats) add(other *RegionStats) {
+	s.Written += other.Written
+	s.Refetched += other.Refetched
+	s.Failed += other.Failed
+	for reason, n := range other.Skipped {
+		s.Skipped[reason] += n
+	}
+}
+
+type RunSummary struct {
+	Version       string                  `json:"version"`
+	StartedAt     time.Time               `json:"startedAt"`
+	EndedAt       time.Time               `json:"endedAt"`
+	WallTime      float64                 `json:"wallTimeSeconds"`
+	HitMaxRuntime bool                    `json:"hitMaxRuntime"` // Whether -max-runtime stopped the run early
+	HourlyCap     int                     `json:"hourlyCap"`
+	PeakHour      int                     `json:"peakHourlyCalls"`
+	KeyCalls      map[string]int64        `json:"keyCalls"` // By key hash
+	Retries       int64                   `json:"retries"`
+	IngestOffsets map[string]int64        `json:"ingestOffsets,omitempty"` // Where each ingest file was read to, for -since-manifest
+	OwnerCache    *CacheStats             `json:"ownerCache,omitempty"`    // Of -enrich owner
+	Totals        *RegionStats            `json:"totals"`
+	Regions       map[string]*RegionStats `json:"regions"`
+}
+
+func newRunSummary() *RunSummary {
+	return &RunSummary{
+		Version:   toolVersion(),
+		StartedAt: time.Now().UTC(),
+		Totals:    newRegionStats(),
+		Regions:   make(map[string]*RegionStats),
+	}
+}
+
+func (s *RunSummary) addRegion(region string, stats *RegionStats) {
+	s.Regions[region] = stats
+	s.Totals.add(stats)
+}
+
+// write finishes the summary and saves it to out/run-summary.json.
+func (s *RunSummary) write() {
+	s.EndedAt = time.Now().UTC()
+	s.WallTime = s.EndedAt.Sub(s.StartedAt).Seconds()
+	s.Totals.APICalls = apiCalls.Load()
+	s.HourlyCap = *hourlyCap
+	s.PeakHour = requests.Peak()
+	s.KeyCalls = keys.Usage()
+	s.Retries = retriesUsed.Load()
+	s.OwnerCache = ownerCache.Stats()
+	// Runs that don't read the ingest directory, or only some of it, carry
+	// the offsets of the other files forward.
+	s.IngestOffsets = previousIngestOffsets()
+	if s.IngestOffsets == nil {
+		s.IngestOffsets = make(map[string]int64)
+	}
+	for fname, offset := range ingestOffsets {
+		s.IngestOffsets[fname] = offset
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(outPath("run-summary.json"), append(data, '\n'), fileMode); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// ingestOffsets records the byte offset each ingest file was read to, for the
+// regions that have been run through to the end.
+var ingestOffsets = make(map[string]int64)
+
+// ingestEnds records, by region, the byte offset each ingest file was read to.
+// They only become ingestOffsets once the region has been run through, so that
+// -since-manifest doesn't pass over ids a run never got to.
+var ingestEnds = make(map[string]map[string]int64)
+
+// finishIngest records that every id read from a region's ingest files has
+// been processed.
+func finishIngest(region string) {
+	for fname, end := range ingestEnds[region] {
+		ingestOffsets[fname] = end
+	}
+}
+
+// previousIngestOffsets returns the ingest offsets recorded by earlier runs,
+// so that -since-manifest only reads ids appended since.
+func previousIngestOffsets() map[string]int64 {
+	data, err := os.ReadFile(outPath("run-summary.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var prev RunSummary
+	if err := json.Unmarshal(data, &prev); err != nil {
+		log.Fatalf("Parsing out/run-summary.json: %s", err)
+	}
+	return prev.IngestOffsets
+}
+
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return info.Main.Version
+}
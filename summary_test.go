@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSinceManifestReadsAppendedIds(t *testing.T) {
+	useDirs(t)
+	oldEnds, oldOffsets := ingestEnds, ingestOffsets
+	defer func() { ingestEnds, ingestOffsets = oldEnds, oldOffsets }()
+	ingestEnds, ingestOffsets = make(map[string]map[string]int64), make(map[string]int64)
+
+	done := filepath.Join(*ingestDir, "done.ndjson")
+	unfinished := filepath.Join(*ingestDir, "unfinished.ndjson")
+	writeFile(t, done, `{"id":"1"}`, `{"id":"2"}`)
+	writeFile(t, unfinished, `{"id":"10"}`)
+
+	// The first run reads everything, but only gets through one region.
+	ingests := loadIngestDir(*ingestDir, previousIngestOffsets())
+	if !slices.Equal(ingests["done"], []string{"1", "2"}) {
+		t.Fatalf("first run read %v, want [1 2]", ingests["done"])
+	}
+	finishIngest("done")
+	newRunSummary().write()
+
+	for fname, id := range map[string]string{done: "3", unfinished: "11"} {
+		f, err := os.OpenFile(fname, os.O_APPEND|os.O_WRONLY, fileMode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(`{"id":"` + id + `"}` + "\n"); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ingests = loadIngestDir(*ingestDir, previousIngestOffsets())
+	if got := ingests["done"]; !slices.Equal(got, []string{"3"}) {
+		t.Errorf("second run read %v from the finished region, want only the appended [3]", got)
+	}
+	if got := ingests["unfinished"]; !slices.Equal(got, []string{"10", "11"}) {
+		t.Errorf("second run read %v from the unfinished region, want all of [10 11]", got)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"unicode/utf8"
+)
+
+var (
+	htmlBreakRe = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlTagRe   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// stripHTML converts Flickr's HTML markup to plain text, turning <br> into
+// newlines, dropping other tags and unescaping entities.
+func stripHTML(s string) string {
+	s = htmlBreakRe.ReplaceAllString(s, "\n")
+	s = htmlTagRe.ReplaceAllString(s, "")
+	return html.UnescapeString(s)
+}
+
+// truncateRunes shortens s to at most n runes, ending it with an ellipsis if
+// anything was cut, and reports whether it was.
+func truncateRunes(s string, n int) (string, bool) {
+	if utf8.RuneCountInString(s) <= n {
+		return s, false
+	}
+	runes := []rune(s)
+	return string(runes[:n-1]) + "…", true
+}
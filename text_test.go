@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		s             string
+		n             int
+		want          string
+		wantTruncated bool
+	}{
+		{"Grasmere", 8, "Grasmere", false},
+		{"Grasmere", 9, "Grasmere", false},
+		{"Grasmere", 7, "Grasme…", true},
+		// Counted in runes, so multibyte text right at the limit is kept.
+		{"Ængland", 7, "Ængland", false},
+		{"東京タワー", 5, "東京タワー", false},
+		{"東京タワー", 4, "東京タ…", true},
+		{"Café au lait", 4, "Caf…", true},
+		{"Cafés", 5, "Cafés", false},
+		{"Cafés", 4, "Caf…", true},
+		{"🏔️ Summit", 3, "🏔️…", true},
+		{"", 5, "", false},
+	}
+	for _, tt := range tests {
+		got, truncated := truncateRunes(tt.s, tt.n)
+		if got != tt.want || truncated != tt.wantTruncated {
+			t.Errorf("truncateRunes(%q, %d) = %q, %v, want %q, %v", tt.s, tt.n, got, truncated, tt.want, tt.wantTruncated)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncateRunes(%q, %d) split a character: %q", tt.s, tt.n, got)
+		}
+		if utf8.RuneCountInString(got) > tt.n {
+			t.Errorf("truncateRunes(%q, %d) = %q, which is over %d runes", tt.s, tt.n, got, tt.n)
+		}
+	}
+}
+
+func TestCreateEntryTruncatesText(t *testing.T) {
+	useFixtures(t)
+	oldLength, oldKeep := *maxTextLength, *keepFullText
+	defer func() { *maxTextLength, *keepFullText = oldLength, oldKeep }()
+	for _, keep := range []bool{false, true} {
+		*maxTextLength, *keepFullText = 10, keep
+		entry, _, err := createEntry(context.Background(), "1001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Title != "Ridge abo…" {
+			t.Errorf("title is %q, want it cut to 10 runes", entry.Title)
+		}
+		wantFull := ""
+		if keep {
+			wantFull = "Ridge above the tarn"
+		}
+		if entry.TitleFull != wantFull {
+			t.Errorf("with -keep-full-text %v, titleFull is %q, want %q", keep, entry.TitleFull, wantFull)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+)
+
+// topupRegion re-runs only the enrichments that existing entries are missing,
+// appending the merged entries, without refetching getInfo or getSizes.
+func topupRegion(ctx context.Context, region string, ids []string, out *entryWriter, existingEntries map[string]Entry) *RegionStats {
+	log.Printf("Topping up region %s", region)
+	stats := newRegionStats()
+	startCalls := apiCalls.Load()
+	defer func() { stats.APICalls = apiCalls.Load() - startCalls }()
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		entry, ok := existingEntries[id]
+		if !ok {
+			continue
+		}
+		missing := missingEnrichments(&entry)
+		if len(missing) == 0 {
+			continue
+		}
+
+		var stillMissing []string
+		enrichCtx, calls := withProvenance(ctx)
+		for _, name := range missing {
+			if err := enrichments[name].Apply(enrichCtx, entry.Id, &entry); err != nil {
+				log.Printf("Warning: %s enrichment of %s failed: %s", name, id, err)
+				stillMissing = append(stillMissing, name)
+			}
+		}
+		if len(stillMissing) == len(missing) {
+			stats.Failed++
+			continue
+		}
+
+		entry.PartialEnrichments = slices.DeleteFunc(entry.PartialEnrichments, func(name string) bool {
+			return !slices.Contains(stillMissing, name)
+		})
+		topupProvenance := calls.provenance()
+		if entry.Provenance != nil {
+			topupProvenance.Methods = append(entry.Provenance.Methods, topupProvenance.Methods...)
+		}
+		entry.Provenance = topupProvenance
+		entry.ContentHash = contentHash(entry)
+		out.Write(entry)
+		existingEntries[id] = entry
+		stats.Written++
+	}
+
+	log.Printf("Topped up %d entries in %s", stats.Written, region)
+	return stats
+}
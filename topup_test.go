@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestTopupPartialEntry(t *testing.T) {
+	useFixtures(t)
+	useDirs(t)
+	old := enabledEnrichments
+	defer func() { enabledEnrichments = old }()
+	enabledEnrichments = []string{"exif"}
+
+	// Hydrated while getExif was failing.
+	failing := overrideDoer{next: httpClient, bodies: map[string]string{
+		"flickr.photos.getExif": `{"stat":"fail","code":2,"message":"Permission denied"}`,
+	}}
+	httpClient = failing
+	partial, _, err := createEntry(context.Background(), "1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial.Region = "region"
+	if !slices.Equal(partial.PartialEnrichments, []string{"exif"}) {
+		t.Fatalf("partial enrichments are %v, want [exif]", partial.PartialEnrichments)
+	}
+	writeEntries(t, outputFile("region"), partial, Entry{Id: "1003", Region: "region"})
+	httpClient = failing.next
+
+	out, existing := openRegionOutput("region")
+	stats := topupRegion(context.Background(), "region", []string{"1001", "1003"}, out, existing)
+	out.Close()
+	// 1003 is missing exif too, having been hydrated without it, but Flickr
+	// has none to give it.
+	if stats.Written != 1 || stats.Failed != 1 {
+		t.Errorf("topped up %d and failed %d, want 1001 topped up and 1003 failed", stats.Written, stats.Failed)
+	}
+
+	entries := parseExisting(outputFile("region"))
+	entry := entries["1001"]
+	if entry.Exif == nil || entry.Exif.Camera != "Fujifilm X-T4" || len(entry.PartialEnrichments) != 0 {
+		t.Errorf("topped up entry has exif %+v and partial enrichments %v, want the exif and none", entry.Exif, entry.PartialEnrichments)
+	}
+	if entry.Title != partial.Title || !entry.RetrievedAt.Equal(partial.RetrievedAt) {
+		t.Error("topping up changed more than the enrichment's fields")
+	}
+	if entry.ContentHash != contentHash(entry) {
+		t.Errorf("contentHash is %q, want %q", entry.ContentHash, contentHash(entry))
+	}
+	if want := []string{"flickr.photos.getInfo", "flickr.photos.getSizes", "flickr.photos.getExif", "flickr.photos.getExif"}; !slices.Equal(entry.Provenance.Methods, want) {
+		t.Errorf("provenance methods are %v, want %v", entry.Provenance.Methods, want)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tracer buffers spans and exports them to -otlp-endpoint using OTLP/HTTP
+// with JSON encoding. It is nil when no endpoint is configured, in which case
+// startSpan returns a nil span and tracing costs nothing.
+var tracer *otlpTracer
+
+// tracesBatchSize is how many finished spans are buffered before exporting.
+const tracesBatchSize = 256
+
+type otlpTracer struct {
+	endpoint string
+	client   *http.Client
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+func newTracer(endpoint string) *otlpTracer {
+	return &otlpTracer{endpoint: endpoint, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type span struct {
+	traceID, spanID, parentID string
+	name                      string
+	kind                      int
+	start, end                time.Time
+	attrs                     map[string]string
+	err                       error
+}
+
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+)
+
+type spanKey struct{}
+
+// startSpan starts a span that is a child of any span in ctx, returning a
+// context carrying it. The span does nothing if tracing is off.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now(), spanID: randomHex(8), attrs: make(map[string]string)}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		s.traceID = randomHex(16)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) set(key, value string) {
+	if s != nil {
+		s.attrs[key] = value
+	}
+}
+
+// End finishes the span, marking it as failed if err is non-nil.
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+
+	tracer.mu.Lock()
+	tracer.spans = append(tracer.spans, s)
+	full := len(tracer.spans) >= tracesBatchSize
+	tracer.mu.Unlock()
+	if full {
+		tracer.flush()
+	}
+}
+
+// flush exports the buffered spans. Export failures are logged rather than
+// fatal, since traces are diagnostic.
+func (t *otlpTracer) flush() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		log.Fatal(err)
+	}
+	resp, err := t.client.Post(t.endpoint+"/v1/traces", "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Warning: exporting %d spans: %s", len(spans), err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Warning: exporting %d spans: HTTP status %d", len(spans), resp.StatusCode)
+	}
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	var out []otlpAttribute
+	for k, v := range attrs {
+		var attr otlpAttribute
+		attr.Key = k
+		attr.Value.StringValue = v
+		out = append(out, attr)
+	}
+	return out
+}
+
+func otlpRequest(spans []*span) any {
+	type otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	type otlpSpan struct {
+		TraceID      string          `json:"traceId"`
+		SpanID       string          `json:"spanId"`
+		ParentSpanID string          `json:"parentSpanId,omitempty"`
+		Name         string          `json:"name"`
+		Kind         int             `json:"kind"`
+		Start        string          `json:"startTimeUnixNano"`
+		End          string          `json:"endTimeUnixNano"`
+		Attributes   []otlpAttribute `json:"attributes"`
+		Status       otlpStatus      `json:"status"`
+	}
+
+	var out []otlpSpan
+	for _, s := range spans {
+		status := otlpStatus{Code: 1} // Ok
+		if s.err != nil {
+			status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		out = append(out, otlpSpan{
+			TraceID:      s.traceID,
+			SpanID:       s.spanID,
+			ParentSpanID: s.parentID,
+			Name:         s.name,
+			Kind:         s.kind,
+			Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+			End:          strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:   otlpAttributes(s.attrs),
+			Status:       status,
+		})
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]string{
+					"service.name":    "contourguessr-picture-hydrator",
+					"service.version": toolVersion(),
+				}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "contourguessr-picture-hydrator"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// Usage is what the owner allows others to do with a photo.
+type Usage struct {
+	CanDownload bool `json:"canDownload"`
+	CanBlog     bool `json:"canBlog"`
+	CanPrint    bool `json:"canPrint"`
+	CanShare    bool `json:"canShare"`
+}
+
+type flickrUsage struct {
+	CanDownload flexInt `json:"candownload"`
+	CanBlog     flexInt `json:"canblog"`
+	CanPrint    flexInt `json:"canprint"`
+	CanShare    flexInt `json:"canshare"`
+}
+
+func (u *flickrUsage) usage() *Usage {
+	if u == nil {
+		return nil
+	}
+	return &Usage{
+		CanDownload: u.CanDownload == 1,
+		CanBlog:     u.CanBlog == 1,
+		CanPrint:    u.CanPrint == 1,
+		CanShare:    u.CanShare == 1,
+	}
+}
+
+var usagePermissions = map[string]func(Usage) bool{
+	"download": func(u Usage) bool { return u.CanDownload },
+	"blog":     func(u Usage) bool { return u.CanBlog },
+	"print":    func(u Usage) bool { return u.CanPrint },
+	"share":    func(u Usage) bool { return u.CanShare },
+}
+
+// requiredUsage is set from -require-usage.
+var requiredUsage []string
+
+func parseRequiredUsage(s string) []string {
+	var required []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := usagePermissions[name]; !ok {
+			log.Fatalf("Invalid -require-usage: unknown permission %q, want download, blog, print or share", name)
+		}
+		required = append(required, name)
+	}
+	return required
+}
+
+// usageAllowed reports whether a photo grants every -require-usage
+// permission. A photo Flickr gave no usage for grants none.
+func usageAllowed(usage *Usage) bool {
+	for _, name := range requiredUsage {
+		if usage == nil || !usagePermissions[name](*usage) {
+			return false
+		}
+	}
+	return true
+}
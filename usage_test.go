@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestUsageAllowed(t *testing.T) {
+	downloadShare := &Usage{CanDownload: true, CanShare: true}
+	all := &Usage{CanDownload: true, CanBlog: true, CanPrint: true, CanShare: true}
+	tests := []struct {
+		require string
+		usage   *Usage
+		want    bool
+	}{
+		{"", nil, true},
+		{"", &Usage{}, true},
+		{"download", downloadShare, true},
+		{"download,share", downloadShare, true},
+		{"download, print", downloadShare, false},
+		{"blog", downloadShare, false},
+		{"download,blog,print,share", all, true},
+		{"share", &Usage{}, false},
+		// A photo with no usage given grants nothing.
+		{"download", nil, false},
+	}
+	old := requiredUsage
+	defer func() { requiredUsage = old }()
+	for _, tt := range tests {
+		requiredUsage = parseRequiredUsage(tt.require)
+		if got := usageAllowed(tt.usage); got != tt.want {
+			t.Errorf("-require-usage %q with %+v: got %v, want %v", tt.require, tt.usage, got, tt.want)
+		}
+	}
+}
+
+func TestFlickrUsage(t *testing.T) {
+	u := &flickrUsage{CanDownload: 1, CanBlog: 0, CanPrint: 0, CanShare: 1}
+	if got, want := *u.usage(), (Usage{CanDownload: true, CanShare: true}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := (*flickrUsage)(nil).usage(); got != nil {
+		t.Errorf("no usage from Flickr gives %+v, want nil", got)
+	}
+}
+
+func TestParseRequiredUsageRejectsUnknown(t *testing.T) {
+	expectFatal(t, `unknown permission "edit"`, func() { parseRequiredUsage("download,edit") })
+}
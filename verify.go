@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runVerify checks that the entries in each region's output are well formed,
+// exiting non-zero if any are not. It makes no API calls.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	onlyNew := fs.Bool("only-new", false, "Only check entries retrieved since -since, or else since the last run started")
+	since := fs.String("since", "", "With -only-new, an RFC 3339 time to check entries retrieved after")
+	fs.Parse(args)
+
+	var cutoff time.Time
+	if *onlyNew {
+		cutoff = verifyCutoff(*since)
+		log.Printf("Only checking entries retrieved after %s", cutoff.Format(time.RFC3339))
+	}
+
+	regions := fs.Args()
+	if len(regions) == 0 {
+		regions = outputRegions(*outDir)
+	}
+
+	var checked, skipped, invalid int
+	for _, region := range regions {
+		for _, fname := range regionFiles(region) {
+			for i, entry := range readEntries(fname) {
+				if *onlyNew && !entry.RetrievedAt.After(cutoff) {
+					skipped++
+					continue
+				}
+				checked++
+				for _, problem := range entryProblems(entry) {
+					log.Printf("%s entry %d (%s): %s", fname, i+1, entry.Id, problem)
+					invalid++
+				}
+			}
+		}
+	}
+
+	log.Printf("Checked %d entries, skipped %d older entries, found %d problems", checked, skipped, invalid)
+	if invalid > 0 {
+		os.Exit(1)
+	}
+}
+
+// verifyCutoff returns the time -since gives, or the start of the last run.
+func verifyCutoff(since string) time.Time {
+	if since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			log.Fatalf("Invalid -since: %s", err)
+		}
+		return t
+	}
+
+	data, err := os.ReadFile(outPath("run-summary.json"))
+	if err != nil {
+		log.Fatalf("-only-new needs -since or a previous run's summary: %s", err)
+	}
+	var summary RunSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		log.Fatalf("Parsing out/run-summary.json: %s", err)
+	}
+	return summary.StartedAt
+}
+
+// outputRegions returns the regions with output files in dir, ignoring the
+// per-region side files such as failures. With -partition-by, they are the
+// directories in dir.
+func outputRegions(dir string) []string {
+	if partitioned() {
+		dirs, err := os.ReadDir(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var regions []string
+		for _, d := range dirs {
+			if d.IsDir() {
+				regions = append(regions, d.Name())
+			}
+		}
+		return regions
+	}
+	fnames, err := filepath.Glob(filepath.Join(dir, "*"+*outExt))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var regions []string
+	for _, fname := range fnames {
+		region := strings.TrimSuffix(filepath.Base(fname), *outExt)
+		if !strings.Contains(region, ".") {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// entryProblems returns what is wrong with an entry, if anything.
+func entryProblems(entry Entry) []string {
+	var problems []string
+	if entry.Id == "" {
+		problems = append(problems, "missing id")
+	}
+	if entry.RetrievedAt.IsZero() {
+		problems = append(problems, "missing retrievedAt")
+	}
+	// Entries from -search and from before sizeCount was added leave it 0.
+	if entry.SizeCount != 0 && len(entry.Sizes) != entry.SizeCount {
+		problems = append(problems, fmt.Sprintf("sizeCount is %d but there are %d sizes", entry.SizeCount, len(entry.Sizes)))
+	}
+	if len(validSizes(entry.Sizes)) != len(entry.Sizes) {
+		problems = append(problems, "has sizes without dimensions or a source")
+	}
+	if (entry.Latitude == "") != (entry.Longitude == "") {
+		problems = append(problems, "has only one of latitude and longitude")
+	}
+	if entry.LatitudeF != nil && (*entry.LatitudeF < -90 || *entry.LatitudeF > 90) {
+		problems = append(problems, fmt.Sprintf("latitude %g is out of range", *entry.LatitudeF))
+	}
+	if entry.LongitudeF != nil && (*entry.LongitudeF < -180 || *entry.LongitudeF > 180) {
+		problems = append(problems, fmt.Sprintf("longitude %g is out of range", *entry.LongitudeF))
+	}
+	return problems
+}
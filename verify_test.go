@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeVerifyOutput writes a region whose old entry has a problem and whose
+// new one doesn't.
+func writeVerifyOutput(t *testing.T) {
+	t.Helper()
+	useDirs(t)
+	writeEntries(t, outputFile("region"),
+		Entry{Id: "1001", Latitude: "54.5", RetrievedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		Entry{Id: "1003", RetrievedAt: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)})
+}
+
+func TestVerifyOnlyNewSkipsOldEntries(t *testing.T) {
+	writeVerifyOutput(t)
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	runVerify([]string{"-only-new", "-since", "2024-03-01T00:00:00Z"})
+	if want := "Checked 1 entries, skipped 1 older entries, found 0 problems"; !strings.Contains(logs.String(), want) {
+		t.Errorf("logged:\n%s\nwant %q", logs.String(), want)
+	}
+}
+
+func TestVerifyOnlyNewSinceLastRun(t *testing.T) {
+	writeVerifyOutput(t)
+	summary := newRunSummary()
+	summary.StartedAt = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	summary.write()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	runVerify([]string{"-only-new"})
+	if want := "Checked 1 entries, skipped 1 older entries"; !strings.Contains(logs.String(), want) {
+		t.Errorf("logged:\n%s\nwant %q", logs.String(), want)
+	}
+}
+
+func TestVerifyChecksEverythingByDefault(t *testing.T) {
+	writeVerifyOutput(t)
+	expectFatal(t, "has only one of latitude and longitude", func() { runVerify(nil) })
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	webhookWorkers  = 4
+	webhookQueue    = 256
+	webhookAttempts = 3
+)
+
+// webhookBackoff is the wait before retrying a delivery, doubling for each
+// retry after it.
+var webhookBackoff = time.Second
+
+// webhook posts each entry written to -webhook-url. Deliveries happen on their
+// own goroutines, outside the Flickr rate limit, and never hold up hydration:
+// if the queue is full or every attempt fails, the entry is appended to
+// out/<region>.webhook-failed.ndjson so it can be replayed later.
+type webhook struct {
+	url    string
+	client *http.Client
+	jobs   chan Entry
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	failed map[string]*lazyEncoder
+}
+
+// hook is nil unless -webhook-url is set.
+var hook *webhook
+
+func newWebhook(url string) *webhook {
+	h := &webhook{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		jobs:   make(chan Entry, webhookQueue),
+		failed: make(map[string]*lazyEncoder),
+	}
+	for range webhookWorkers {
+		h.wg.Add(1)
+		go h.work()
+	}
+	return h
+}
+
+// Send queues an entry for delivery without blocking.
+func (h *webhook) Send(entry Entry) {
+	if h == nil {
+		return
+	}
+	select {
+	case h.jobs <- entry:
+	default:
+		log.Printf("Warning: webhook queue is full, recording %s as undelivered", entry.Id)
+		h.recordFailed(entry)
+	}
+}
+
+func (h *webhook) work() {
+	defer h.wg.Done()
+	for entry := range h.jobs {
+		var err error
+		for attempt := range webhookAttempts {
+			if attempt > 0 {
+				time.Sleep(webhookBackoff << (attempt - 1))
+			}
+			if err = h.deliver(entry); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("Warning: delivering %s to webhook: %s", entry.Id, err)
+			h.recordFailed(entry)
+		}
+	}
+}
+
+func (h *webhook) deliver(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (h *webhook) recordFailed(entry Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, ok := h.failed[entry.Region]
+	if !ok {
+		f = &lazyEncoder{fname: outPath(entry.Region + ".webhook-failed.ndjson")}
+		h.failed[entry.Region] = f
+	}
+	f.Encode(entry)
+}
+
+// Close waits for queued deliveries to finish.
+func (h *webhook) Close() {
+	if h == nil {
+		return
+	}
+	close(h.jobs)
+	h.wg.Wait()
+	for _, f := range h.failed {
+		f.Close()
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures is how many times in a row the receiver fails for each id.
+		failures      map[string]int
+		wantDelivered []string
+		wantFailed    []string
+	}{
+		{"delivered", nil, []string{"1001", "1003"}, nil},
+		{"delivered on a retry", map[string]int{"1001": webhookAttempts - 1}, []string{"1001", "1003"}, nil},
+		{"undeliverable", map[string]int{"1001": webhookAttempts}, []string{"1003"}, []string{"1001"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useDirs(t)
+			old := webhookBackoff
+			defer func() { webhookBackoff = old }()
+			webhookBackoff = 0
+
+			var mu sync.Mutex
+			var delivered []string
+			attempts := make(map[string]int)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("got a %s of %s, want a POST of JSON", r.Method, r.Header.Get("Content-Type"))
+				}
+				body, _ := io.ReadAll(r.Body)
+				var entry Entry
+				if err := json.Unmarshal(body, &entry); err != nil {
+					t.Errorf("posted %s: %s", body, err)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				attempts[entry.Id]++
+				if attempts[entry.Id] <= tt.failures[entry.Id] {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				delivered = append(delivered, entry.Id)
+			}))
+			defer server.Close()
+
+			h := newWebhook(server.URL)
+			h.Send(Entry{Id: "1001", Region: "region"})
+			h.Send(Entry{Id: "1003", Region: "region"})
+			h.Close()
+
+			sort.Strings(delivered)
+			if !slices.Equal(delivered, tt.wantDelivered) {
+				t.Errorf("delivered %v, want %v", delivered, tt.wantDelivered)
+			}
+			fname := outPath("region.webhook-failed.ndjson")
+			if tt.wantFailed == nil {
+				if _, err := os.Stat(fname); !os.IsNotExist(err) {
+					t.Errorf("wrote %s, want every entry delivered", fname)
+				}
+				return
+			}
+			if got := parseFailures(fname); !slices.Equal(got, tt.wantFailed) {
+				t.Errorf("recorded %v as undelivered, want %v", got, tt.wantFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"time"
+)
+
+const writerBuffer = 64
+
+// flushInterval bounds how long a written entry can sit in a -write-buffer
+// buffer, and so how many entries a crash can lose. A graceful shutdown loses
+// none, since closing the writer flushes it. Only tests change it.
+var flushInterval = 10 * time.Second
+
+// entryWriter is the single owner of an output file's encoder. Entries are sent
+// to its goroutine over a buffered channel, so producers block once the buffer
+// is full rather than racing on the file. It also drops any entry whose
+// dedupeKey it has already written this run.
+type entryWriter struct {
+	entries chan Entry
+	done    chan struct{}
+}
+
+func newEntryWriter(w io.Writer) *entryWriter {
+	out := newOutputBuffer(w)
+	enc := json.NewEncoder(out)
+	return startEntryWriter(func(Entry) *json.Encoder { return enc }, out.flush, func() {})
+}
+
+// startEntryWriter starts a writer that encodes each entry with the encoder
+// encoderFor returns for it. It calls flush every flushInterval, whether or
+// not entries are arriving, and once every entry is written, then calls done.
+func startEntryWriter(encoderFor func(Entry) *json.Encoder, flush, done func()) *entryWriter {
+	ew := &entryWriter{
+		entries: make(chan Entry, writerBuffer),
+		done:    make(chan struct{}),
+	}
+	go ew.run(encoderFor, flush, done)
+	return ew
+}
+
+func (ew *entryWriter) run(encoderFor func(Entry) *json.Encoder, flush, done func()) {
+	defer close(ew.done)
+	defer done()
+	defer flush()
+	written := make(map[string]bool)
+	// Flushing on a ticker rather than as entries arrive means the last
+	// entries before a lull, such as a slow photo, aren't left in the buffer.
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-ew.entries:
+			if !ok {
+				return
+			}
+			key := dedupeKey(entry)
+			if written[key] {
+				continue
+			}
+			checkSchema(entry)
+			if err := encoderFor(entry).Encode(entry); err != nil {
+				log.Fatal(err)
+			}
+			written[key] = true
+			hook.Send(entry)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// outputBuffer batches writes to an output file into -write-buffer sized
+// chunks, rather than making a write call per entry.
+type outputBuffer struct {
+	io.Writer
+	buf *bufio.Writer // nil if unbuffered
+}
+
+func newOutputBuffer(w io.Writer) *outputBuffer {
+	if *writeBuffer <= 0 {
+		return &outputBuffer{Writer: w}
+	}
+	buf := bufio.NewWriterSize(w, *writeBuffer)
+	return &outputBuffer{Writer: buf, buf: buf}
+}
+
+func (b *outputBuffer) flush() {
+	if b.buf == nil {
+		return
+	}
+	if err := b.buf.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Write queues an entry, blocking while the buffer is full.
+func (ew *entryWriter) Write(entry Entry) {
+	ew.entries <- entry
+}
+
+// Close drains the queued entries and waits for them to be written and
+// flushed. The underlying file must only be closed after this returns.
+func (ew *entryWriter) Close() {
+	close(ew.entries)
+	<-ew.done
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// choppyWriter writes each call in small pieces, so that writes from more than
+// one goroutine would interleave mid-line.
+type choppyWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *choppyWriter) Write(p []byte) (int, error) {
+	for i := 0; i < len(p); i += 7 {
+		w.mu.Lock()
+		w.buf.Write(p[i:min(i+7, len(p))])
+		w.mu.Unlock()
+	}
+	return len(p), nil
+}
+
+func TestEntryWriterStress(t *testing.T) {
+	for _, size := range []int{0, 64, 64 << 10} {
+		t.Run(fmt.Sprintf("write-buffer=%d", size), func(t *testing.T) {
+			old := *writeBuffer
+			defer func() { *writeBuffer = old }()
+			*writeBuffer = size
+
+			const producers, perProducer = 16, 200
+			var out choppyWriter
+			ew := newEntryWriter(&out)
+			var wg sync.WaitGroup
+			for p := range producers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range perProducer {
+						id := fmt.Sprintf("%d-%d", p, i)
+						ew.Write(Entry{Id: id, Description: strings.Repeat(id+" ", 50)})
+						// Every id is also sent again, which must be dropped.
+						ew.Write(Entry{Id: id, Description: "duplicate"})
+					}
+				}()
+			}
+			wg.Wait()
+			ew.Close()
+
+			seen := make(map[string]bool)
+			scanner := bufio.NewScanner(&out.buf)
+			scanner.Buffer(nil, 1<<20)
+			for scanner.Scan() {
+				var entry Entry
+				if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+					t.Fatalf("partial or interleaved line %q: %s", scanner.Text(), err)
+				}
+				if seen[entry.Id] {
+					t.Fatalf("%s written twice", entry.Id)
+				}
+				if want := strings.Repeat(entry.Id+" ", 50); entry.Description != want {
+					t.Fatalf("%s has description %q, want the first one written", entry.Id, entry.Description)
+				}
+				seen[entry.Id] = true
+			}
+			if err := scanner.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if len(seen) != producers*perProducer {
+				t.Errorf("wrote %d entries, want %d", len(seen), producers*perProducer)
+			}
+		})
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that can be read while the writer writes it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func TestEntryWriterFlushesWhileIdle(t *testing.T) {
+	oldBuffer, oldInterval := *writeBuffer, flushInterval
+	defer func() { *writeBuffer, flushInterval = oldBuffer, oldInterval }()
+	*writeBuffer, flushInterval = 64<<10, 10*time.Millisecond
+
+	var out lockedBuffer
+	ew := newEntryWriter(&out)
+	defer ew.Close()
+	ew.Write(Entry{Id: "1"})
+	// No more entries arrive, so only the ticker can flush the first.
+	deadline := time.Now().Add(time.Second)
+	for out.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("entry still buffered a second after it was written, want the ticker to flush it")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func BenchmarkWriteBuffer(b *testing.B) {
+	for _, size := range []int{0, 64 << 10} {
+		b.Run(fmt.Sprintf("write-buffer=%d", size), func(b *testing.B) {
+			old := *writeBuffer
+			defer func() { *writeBuffer = old }()
+			*writeBuffer = size
+
+			f, err := os.Create(filepath.Join(b.TempDir(), "out.ndjson"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer f.Close()
+			entry := Entry{Id: "53012345678", Title: "Ridge", Description: strings.Repeat("x", 200)}
+			b.ResetTimer()
+			ew := newEntryWriter(f)
+			for i := range b.N {
+				// The writer drops repeated ids, so each entry needs its own.
+				entry.Id = fmt.Sprint(i)
+				ew.Write(entry)
+			}
+			ew.Close()
+		})
+	}
+}